@@ -1,108 +1,139 @@
 package goloaderbuilder
 
 import (
-	"bytes"
-	"flag"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 type BuildConfig struct {
-	GoBinary        string   // path to go binary, defaults to "go"
-	ExtraBuildFlags []string // build flags
-	BuildEnv        []string // build env
-	BuildPaths      []string // build path
-	PkgPath         string   // package path
-	TargetDir       string   // target directory path
-	TargetPath      string   // output path, output is a library file
-	WorkDir         string   // work directory
-	KeepWorkDir     bool     // keep work directory
-	DebugLog        bool     // output debug build log
-	Dynlink         bool     // enable position independent code
+	GoBinary        string        // path to go binary, defaults to "go"
+	ExtraBuildFlags []string      // build flags
+	BuildEnv        []string      // build env
+	BuildPaths      []string      // build path
+	PkgPath         string        // package path
+	TargetDir       string        // target directory path
+	TargetPath      string        // output path, output is a library file
+	WorkDir         string        // work directory
+	KeepWorkDir     bool          // keep work directory
+	DebugLog        bool          // output debug build log
+	Dynlink         DynlinkMode   // position independent code, defaults to the platforms which require it
+	Timeout         time.Duration // timeout of every go command, zero means no timeout
+	Toolchain       Toolchain     // runs go commands, defaults to an ExecToolchain using GoBinary
+	Concurrency     int           // maximum number of concurrent dependency builds, defaults to the number of CPUs
+	FailFast        bool          // cancel outstanding dependency builds on the first failure
+	CacheDir        string        // build cache directory, defaults to TargetDir/.cache
+	DisableCache    bool          // bypass the build cache, archives are neither read from nor stored in it
+	ManifestPath    string        // path of the manifest written by BuildWithDependencies, defaults to the root archive path with a .manifest.json extension so that builds sharing TargetDir keep one manifest per root package
+	GOOS            string        // target operating system, defaults to go env GOOS
+	GOARCH          string        // target architecture, defaults to go env GOARCH
+	Microarch       string        // target microarchitecture (GOAMD64, GOARM64...), defaults to go env
+
+	ResolveMode ResolveMode // how missing dependencies are resolved, defaults to ResolveModfile
+	Offline     bool        // never access the network, missing modules fail the build with an *OfflineError
+	MirrorDir   string      // file:// GOPROXY directory written by ExportModules, used in offline mode
+	ModMode     ModMode     // -mod flag of every go command, such as ModVendor
+	GoWork      string      // go.work file of a multi-module workspace, "off" disables workspace mode
+	EnvPolicy   EnvPolicy   // environment of every go command, BuildEnv is always applied on top of it
+	EnvAllow    []string    // variables kept from the environment with EnvHermetic, defaults to DefaultEnvAllow
+	GoCache     string      // GOCACHE of every go command, defaults to the go env value
+	GoModCache  string      // GOMODCACHE of every go command, defaults to the go env value
+	CgoMode     CgoMode     // how packages using cgo are handled, defaults to CgoReject
+
+	MainPathMode MainPathMode // how the package path of a main package is derived when PkgPath is not set
+	MainEntry    string       // exported name main.main is renamed to, defaults to DefaultMainEntry
+
+	Host                 string // host executable the archives are built for, module versions are compared with its build info
+	PinHostModules       bool   // build with the host versions of mismatching modules, required in a copy of go.mod passed with -modfile
+	InheritHostSettings  bool   // build with the -tags, GOEXPERIMENT, -race and -trimpath settings of Host, conflicting flags are errors
+	SkipHostDependencies bool   // only build the dependencies defining symbols which the archives need and Host lacks
+
+	goEnv        map[string]string   // go env values shared by all dependency builds
+	envGoFlags   string              // effective GOFLAGS, read from go env when building offline
+	modFile      string              // go.mod copy passed with -modfile in ResolveModfile mode
+	resolve      *ResolveReport      // report of the last dependency resolution
+	hostModules  *HostModuleReport   // module versions compared with Host
+	hostSettings *HostSettings       // build settings of Host with InheritHostSettings
+	skipped      []SkippedDependency // dependencies provided by Host with SkipHostDependencies
+	overlay      *overlay            // generated sources passed with -overlay
+	entry        string              // runnable symbol of a main root package
 }
 
-func mergeBuildFlags(extraBuildFlags []string, dynlink bool) []string {
-	gcFlags := []string{}
-	if dynlink {
-		gcFlags = append(gcFlags, "-dynlink")
-	}
-	buildFlags := []string{}
-	for _, buildflag := range extraBuildFlags {
-		if strings.HasPrefix(strings.TrimLeft(buildflag, " "), "-gcflags") {
-			flagSet := flag.NewFlagSet("", flag.ContinueOnError)
-			f := flagSet.String("gcflags", "", "")
-			err := flagSet.Parse([]string{buildflag})
-			if err != nil {
-				panic(err)
-			}
-			gcFlags = append(gcFlags, *f)
-		} else {
-			buildFlags = append(buildFlags, buildflag)
-		}
+func (config *BuildConfig) toolchain() Toolchain {
+	if config.Toolchain != nil {
+		return config.Toolchain
 	}
+	return &ExecToolchain{GoBinary: config.GoBinary}
+}
 
-	if len(gcFlags) > 0 {
-		buildFlags = append(buildFlags, fmt.Sprintf(`-gcflags=%s`, strings.Join(gcFlags, " ")))
+func (config *BuildConfig) toolRunner() ToolRunner {
+	if runner, ok := config.Toolchain.(ToolRunner); ok {
+		return runner
 	}
-	return buildFlags
+	return &ExecToolchain{GoBinary: config.GoBinary}
 }
 
-func execBuild(config *BuildConfig, wg *sync.WaitGroup) {
+func (config *BuildConfig) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if config.Timeout > 0 {
+		return context.WithTimeout(ctx, config.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
 
-	var args = []string{"build"}
-	args = append(args, mergeBuildFlags(config.ExtraBuildFlags, config.Dynlink)...)
+func execBuild(ctx context.Context, config *BuildConfig) error {
+	args, err := config.buildFlags()
+	if err != nil {
+		return err
+	}
 	args = append(args, "-o", config.TargetPath)
 	args = append(args, config.BuildPaths...)
 
-	if len(config.BuildPaths) == 1 {
-		goPath := os.Getenv("GOPATH")
-		if !strings.HasPrefix(config.BuildPaths[0], goPath) {
-			if _, err := os.Stat(config.TargetPath); err == nil {
-				if wg != nil {
-					wg.Done()
-				}
-				return
-			}
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	stdout, err := config.toolchain().Build(cmdCtx, &Invocation{Dir: config.WorkDir, Env: config.environ(), Args: args})
+	if err != nil {
+		if cmdCtx.Err() != nil {
+			// a canceled go build may leave a partial archive behind
+			os.Remove(config.TargetPath)
 		}
+		return err
 	}
 
-	cmd := exec.Command(config.GoBinary, args...)
-	cmd.Dir = config.WorkDir
-	cmd.Env = append(cmd.Env, config.BuildEnv...)
-
-	stdoutBuffer := &bytes.Buffer{}
-	stderrBuffer := &bytes.Buffer{}
-
-	cmd.Stdout = stdoutBuffer
-	cmd.Stderr = stderrBuffer
-
-	if err := cmd.Run(); err != nil {
-		fmt.Println("could not build with cmd:\n'%s': %w.\nstdout:\n%s\nstderr:\n%s",
-			strings.Join(cmd.Args, " "), err, stdoutBuffer, stderrBuffer)
-	}
-
-	if config.DebugLog && stdoutBuffer.Len() > 0 {
-		fmt.Println(stdoutBuffer)
-	}
-
-	if wg != nil {
-		wg.Done()
+	if config.DebugLog && len(stdout) > 0 {
+		fmt.Println(string(stdout))
 	}
-
+	return nil
 }
 
-func initConfig(config *BuildConfig, absPathEnable bool) error {
+func initConfig(ctx context.Context, config *BuildConfig, absPathEnable bool) error {
 	if config.GoBinary == "" {
 		config.GoBinary = "go"
 	}
+	config.entry = ""
+	config.hostModules = nil
 
 	if len(config.BuildPaths) == 0 {
 		return fmt.Errorf("source file path is empty")
 	}
+	if config.Host != "" {
+		path, err := filepath.Abs(config.Host)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path at %s: %w", config.Host, err)
+		}
+		config.Host = path
+	}
+	if err := config.loadHostSettings(); err != nil {
+		return err
+	}
+	if _, err := mergeBuildFlags(config.ExtraBuildFlags, config.dynlink(), config.hostSettings); err != nil {
+		return err
+	}
 
 	if absPathEnable {
 		for i := range config.BuildPaths {
@@ -134,127 +165,265 @@ func initConfig(config *BuildConfig, absPathEnable bool) error {
 	}
 	config.TargetDir = path
 
-	path = strings.TrimSuffix(config.BuildPaths[0], ".go")
-	goPath := os.Getenv("GOPATH")
-	if path == config.BuildPaths[0] {
-		if strings.HasPrefix(path, goPath) {
-			path = strings.TrimPrefix(path, filepath.Join(goPath, "src", ""))
-			path = filepath.Join(config.TargetDir, path, "")
-		} else {
-			path = filepath.Join(config.TargetDir, config.PkgPath)
-		}
-	} else {
-		if strings.HasPrefix(path, goPath) {
-			path = strings.TrimPrefix(path, filepath.Join(goPath, "src", ""))
-			path = filepath.Join(config.TargetDir, filepath.Dir(path))
-		} else {
-			path = filepath.Join(config.TargetDir, filepath.Base(path))
+	if config.MirrorDir != "" {
+		path, err = filepath.Abs(config.MirrorDir)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path at %s: %w", config.MirrorDir, err)
 		}
+		config.MirrorDir = path
 	}
-	config.TargetPath = path
-	path, err = filepath.Abs(path)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path at %s: %w", path, err)
+
+	if config.goEnv == nil {
+		if err = config.loadGoFlags(ctx); err != nil {
+			return err
+		}
 	}
-	err = os.MkdirAll(config.TargetPath, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("could not create new temp dir at %s: %w", config.TargetPath, err)
+	if _, err = config.loadGoEnv(ctx); err != nil {
+		return err
 	}
-	config.TargetPath = filepath.Join(config.TargetPath, filepath.Base(config.TargetPath)) + ".a"
 	return nil
 }
 
-func getPkg(goBinary, absPath, workDir, targetPath string) (*Package, error) {
-	pkg, err := GoList(goBinary, absPath, workDir, targetPath)
+func getPkg(ctx context.Context, config *BuildConfig, workDir string, absPaths ...string) (*Package, map[string]*Package, error) {
+	goList := func(workDir string) (*Package, map[string]*Package, error) {
+		cmdCtx, cancel := config.commandContext(ctx)
+		defer cancel()
+		flags, err := config.listFlags()
+		if err != nil {
+			return nil, nil, err
+		}
+		graph, order, err := goListDeps(cmdCtx, config.toolchain(), workDir, config.environ(), flags, absPaths...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(order) == 0 {
+			return nil, nil, fmt.Errorf("go list reported no package for %s", strings.Join(absPaths, " "))
+		}
+		pkg := graph[order[len(order)-1]]
+		if pkg.Error != nil && len(pkg.DepsErrors) == 0 {
+			return nil, nil, packageErrors(pkg)
+		}
+		if len(pkg.GoFiles)+len(pkg.CgoFiles) == 0 {
+			return nil, nil, fmt.Errorf("no Go files found in %s", strings.Join(absPaths, " "))
+		}
+		return pkg, graph, nil
+	}
 
+	pkg, graph, err := goList(workDir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(pkg.DepsErrors) > 0 {
-		err = GoModDownload(goBinary, workDir)
-		if err != nil {
-			return nil, err
+		if config.Offline {
+			return nil, nil, offlineError(pkg)
 		}
-		err = GoGet(goBinary, workDir, workDir)
-		if err != nil {
-			return nil, err
+		if err = resolveDependencies(ctx, config, workDir, pkg); err != nil {
+			return nil, nil, err
 		}
-		pkg, err = GoList(goBinary, absPath, "", targetPath)
+		pkg, graph, err = goList(workDir)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if len(pkg.DepsErrors) > 0 {
-			return nil, fmt.Errorf("could not resolve dependency errors after go mod download + go get: %s", pkg.DepsErrors[0].Err)
+			return nil, nil, fmt.Errorf("could not resolve dependency errors: %w", packageErrors(pkg))
 		}
 	}
-	return pkg, err
+	if config.Host != "" && config.hostModules == nil {
+		pinned, err := alignHostModules(ctx, config, workDir, graph)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pinned {
+			if pkg, graph, err = goList(workDir); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return pkg, graph, err
+}
+
+func buildPkg(ctx context.Context, config *BuildConfig, pkg *Package, graph map[string]*Package) (bool, error) {
+	if err := config.checkCgo(pkg); err != nil {
+		return false, err
+	}
+	keyer, err := newCacheKeyer(ctx, config, graph)
+	if err != nil {
+		return false, err
+	}
+	key, err := keyer.key(pkg)
+	if err != nil {
+		return false, fmt.Errorf("could not compute cache key of %s: %w", pkg.ImportPath, err)
+	}
+	cached, err := cachedBuild(ctx, config, key)
+	var buildErr *BuildError
+	if errors.As(err, &buildErr) && config.overlay != nil {
+		config.overlay.restorePaths(buildErr)
+	}
+	return cached, err
 }
 
 func BuildGoFiles(config *BuildConfig) (*Package, error) {
+	return BuildGoFilesContext(context.Background(), config)
+}
+
+func BuildGoFilesContext(ctx context.Context, config *BuildConfig) (*Package, error) {
 	if !config.KeepWorkDir {
 		defer os.RemoveAll(config.WorkDir)
 	}
+	pkg, _, err := buildGoFiles(ctx, config)
+	return pkg, err
+}
 
-	if err := initConfig(config, true); err != nil {
-		return nil, err
+func buildGoFiles(ctx context.Context, config *BuildConfig) (*Package, bool, error) {
+	defer config.removeOverlay()
+
+	if err := initConfig(ctx, config, true); err != nil {
+		return nil, false, err
 	}
 
 	absPath := config.BuildPaths[0]
 	workDir := filepath.Dir(absPath)
 	config.WorkDir = workDir
 
-	pkg, err := getPkg(config.GoBinary, absPath, workDir, config.TargetPath)
+	pkg, graph, err := getPkg(ctx, config, workDir, config.BuildPaths...)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if pkg.Name == "main" {
+		if pkg, graph, err = prepareMain(ctx, config, pkg); err != nil {
+			return nil, false, err
+		}
+	}
+	if err = config.setTarget(config.importPathOf(pkg)); err != nil {
+		return nil, false, err
 	}
 
-	execBuild(config, nil)
-	return pkg, nil
+	cached, err := buildPkg(ctx, config, pkg, graph)
+	if err != nil {
+		return nil, false, err
+	}
+	return pkg, cached, nil
 }
 
+// BuildDepPackage starts building the archive of a dependency package in the background and
+// calls wg.Done once it is built. The error of the background build is only logged, use
+// BuildDepPackageAsync to receive it.
 func BuildDepPackage(config *BuildConfig, wg *sync.WaitGroup) (*Package, error) {
-	if err := initConfig(config, false); err != nil {
+	return BuildDepPackageContext(context.Background(), config, wg)
+}
+
+func BuildDepPackageContext(ctx context.Context, config *BuildConfig, wg *sync.WaitGroup) (*Package, error) {
+	pkg, wait, err := BuildDepPackageAsyncContext(ctx, config)
+	if err != nil {
 		return nil, err
 	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := wait(); err != nil {
+			log.Printf("goloaderbuilder failed to build %s: %s\n", config.PkgPath, err)
+		}
+	}()
+	return pkg, nil
+}
+
+func BuildDepPackageAsync(config *BuildConfig) (*Package, func() error, error) {
+	return BuildDepPackageAsyncContext(context.Background(), config)
+}
+
+// BuildDepPackageAsyncContext starts building the archive of a dependency package in the background.
+// The returned function waits for the build and returns its error, a *BuildError when go build failed.
+func BuildDepPackageAsyncContext(ctx context.Context, config *BuildConfig) (*Package, func() error, error) {
+	if err := initConfig(ctx, config, false); err != nil {
+		return nil, nil, err
+	}
 	if len(config.BuildPaths) != 1 {
-		return nil, fmt.Errorf("invalid source package path")
+		return nil, nil, fmt.Errorf("invalid source package path")
 	}
 
-	pkg, err := getPkg(config.GoBinary, config.BuildPaths[0], config.WorkDir, config.TargetPath)
+	pkg, graph, err := getPkg(ctx, config, config.WorkDir, config.BuildPaths[0])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if err = config.setTarget(config.importPathOf(pkg)); err != nil {
+		return nil, nil, err
+	}
+	if err = config.checkCgo(pkg); err != nil {
+		return nil, nil, err
+	}
+	keyer, err := newCacheKeyer(ctx, config, graph)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := keyer.key(pkg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not compute cache key of %s: %w", pkg.ImportPath, err)
 	}
 
-	wg.Add(1)
-	go execBuild(config, wg)
-	return pkg, nil
+	done := make(chan struct{})
+	var buildErr error
+	go func() {
+		defer close(done)
+		release, err := acquireDepBuildSlot(ctx, config)
+		if err != nil {
+			buildErr = err
+			return
+		}
+		defer release()
+		_, buildErr = cachedBuild(ctx, config, key)
+	}()
+	return pkg, func() error {
+		<-done
+		return buildErr
+	}, nil
 }
 
 func BuildGoPackage(config *BuildConfig) (*Package, error) {
+	return BuildGoPackageContext(context.Background(), config)
+}
+
+func BuildGoPackageContext(ctx context.Context, config *BuildConfig) (*Package, error) {
 	if !config.KeepWorkDir {
 		defer os.RemoveAll(config.WorkDir)
 	}
-	if err := initConfig(config, true); err != nil {
-		return nil, err
+	pkg, _, err := buildGoPackage(ctx, config)
+	return pkg, err
+}
+
+func buildGoPackage(ctx context.Context, config *BuildConfig) (*Package, bool, error) {
+	defer config.removeOverlay()
+	if err := initConfig(ctx, config, true); err != nil {
+		return nil, false, err
 	}
 	if len(config.BuildPaths) != 1 {
-		return nil, fmt.Errorf("invalid source package path")
+		return nil, false, fmt.Errorf("invalid source package path")
 	}
 	absPath := config.BuildPaths[0]
 	fileInfo, err := os.Stat(absPath)
 	if err != nil {
-		return nil, fmt.Errorf("could not stat path at %s: %w", absPath, err)
+		return nil, false, fmt.Errorf("could not stat path at %s: %w", absPath, err)
 	}
 	if !fileInfo.IsDir() {
-		return nil, fmt.Errorf("path at %s is not a directory", absPath)
+		return nil, false, fmt.Errorf("path at %s is not a directory", absPath)
 	}
 
-	pkg, err := getPkg(config.GoBinary, absPath, config.WorkDir, config.TargetPath)
+	pkg, graph, err := getPkg(ctx, config, config.WorkDir, absPath)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if pkg.Name == "main" {
+		if pkg, graph, err = prepareMain(ctx, config, pkg); err != nil {
+			return nil, false, err
+		}
+	}
+	if err = config.setTarget(config.importPathOf(pkg)); err != nil {
+		return nil, false, err
 	}
 
-	execBuild(config, nil)
-	return pkg, nil
+	cached, err := buildPkg(ctx, config, pkg, graph)
+	if err != nil {
+		return nil, false, err
+	}
+	return pkg, cached, nil
 }
@@ -0,0 +1,224 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const cacheKeyVersion = "goloaderbuilder cache v1"
+
+// goEnvKeys are the go env values which change the content of a compiled archive.
+var goEnvKeys = []string{
+	"GOVERSION", "GOROOT", "GOOS", "GOARCH", "GOEXPERIMENT", "GOFLAGS", "GOWORK",
+	"GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM",
+	"CGO_ENABLED", "CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_FFLAGS", "CGO_LDFLAGS", "CC", "CXX",
+}
+
+func (config *BuildConfig) cacheDir() string {
+	if config.CacheDir != "" {
+		return config.CacheDir
+	}
+	return filepath.Join(config.TargetDir, ".cache")
+}
+
+func (config *BuildConfig) loadGoEnv(ctx context.Context) (map[string]string, error) {
+	if config.goEnv != nil {
+		return config.goEnv, nil
+	}
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	values, err := goEnv(cmdCtx, config.toolchain(), config.WorkDir, config.environ(), append(goEnvKeys, goPathKeys...)...)
+	if err != nil {
+		return nil, err
+	}
+	config.goEnv = values
+	return values, nil
+}
+
+type cacheKeyer struct {
+	config *BuildConfig
+	goEnv  map[string]string
+	graph  map[string]*Package
+	keys   map[string]string
+}
+
+func newCacheKeyer(ctx context.Context, config *BuildConfig, graph map[string]*Package) (*cacheKeyer, error) {
+	values, err := config.loadGoEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheKeyer{config: config, goEnv: values, graph: graph, keys: map[string]string{}}, nil
+}
+
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileHash := sha256.New()
+	if _, err = io.Copy(fileHash, f); err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "file %s %x\n", filepath.Base(path), fileHash.Sum(nil))
+	return nil
+}
+
+func sourceFiles(pkg *Package) []string {
+	var files []string
+	for _, list := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.MFiles, pkg.HFiles,
+		pkg.FFiles, pkg.SFiles, pkg.SwigFiles, pkg.SwigCXXFiles, pkg.SysoFiles, pkg.EmbedFiles} {
+		files = append(files, list...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// immutable reports whether the sources of pkg are identified by its module version.
+func immutable(pkg *Package) bool {
+	if pkg.Standard {
+		return true
+	}
+	if pkg.Module == nil || pkg.Module.Main {
+		return false
+	}
+	if pkg.Module.Dir == "" {
+		// vendored packages may be edited in place
+		return false
+	}
+	if pkg.Module.Replace != nil {
+		return pkg.Module.Replace.Version != ""
+	}
+	return pkg.Module.Version != ""
+}
+
+// key returns the cache key of the archive of pkg. It covers the sources of pkg, the go.mod and
+// go.sum of its module, the go version and target platform, the build flags and environment,
+// and the keys of every imported package.
+func (k *cacheKeyer) key(pkg *Package) (string, error) {
+	if key, ok := k.keys[pkg.ImportPath]; ok {
+		return key, nil
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", cacheKeyVersion)
+	for _, key := range goEnvKeys {
+		fmt.Fprintf(h, "env %s=%s\n", key, k.goEnv[key])
+	}
+	env := k.config.overrideEnv()
+	sort.Strings(env)
+	for _, kv := range env {
+		fmt.Fprintf(h, "buildenv %s\n", kv)
+	}
+	buildFlags, err := k.config.buildFlags()
+	if err != nil {
+		return "", err
+	}
+	for _, buildFlag := range buildFlags {
+		// the content of overlaid sources is hashed below, not the path of the overlay
+		if !strings.HasPrefix(buildFlag, "-overlay=") {
+			fmt.Fprintf(h, "flag %s\n", buildFlag)
+		}
+	}
+	fmt.Fprintf(h, "package %s\n", pkg.ImportPath)
+
+	if immutable(pkg) {
+		if pkg.Module != nil {
+			module := pkg.Module
+			if module.Replace != nil {
+				module = module.Replace
+			}
+			fmt.Fprintf(h, "module %s@%s\n", module.Path, module.Version)
+		}
+	} else {
+		for _, file := range sourceFiles(pkg) {
+			if err := hashFile(h, k.config.sourcePath(filepath.Join(pkg.Dir, file))); err != nil {
+				return "", err
+			}
+		}
+		if pkg.Module != nil && pkg.Module.GoMod != "" {
+			for _, file := range []string{pkg.Module.GoMod, filepath.Join(filepath.Dir(pkg.Module.GoMod), "go.sum")} {
+				if err := hashFile(h, file); err != nil && !os.IsNotExist(err) {
+					return "", err
+				}
+			}
+		}
+	}
+
+	for _, importPath := range resolvedImports(pkg) {
+		dep, ok := k.graph[importPath]
+		if !ok {
+			fmt.Fprintf(h, "import %s\n", importPath)
+			continue
+		}
+		depKey, err := k.key(dep)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "import %s %s\n", importPath, depKey)
+	}
+
+	key := hex.EncodeToString(h.Sum(nil))
+	k.keys[pkg.ImportPath] = key
+	return key, nil
+}
+
+func cachePath(cacheDir, key, ext string) string {
+	return filepath.Join(cacheDir, key[:2], key+ext)
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err = os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// cachedBuild builds the archive config.TargetPath, reusing the archive stored under key in the
+// build cache when there is one. It reports whether the archive came from the cache.
+func cachedBuild(ctx context.Context, config *BuildConfig, key string) (bool, error) {
+	if config.DisableCache {
+		return false, execBuild(ctx, config)
+	}
+	cacheDir := config.cacheDir()
+	if _, err := os.Stat(cachePath(cacheDir, key, ".a")); err == nil {
+		if err = copyFile(config.TargetPath, cachePath(cacheDir, key, ".a")); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if err := execBuild(ctx, config); err != nil {
+		return false, err
+	}
+	if err := copyFile(cachePath(cacheDir, key, ".a"), config.TargetPath); err != nil {
+		return false, fmt.Errorf("could not store %s in build cache: %w", config.TargetPath, err)
+	}
+	return false, nil
+}
@@ -0,0 +1,137 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/m\n",
+		"a/a.go":  "package a\n",
+		"b/b.go":  "package b\n\nimport _ \"example.com/m/a\"\n",
+		"go.sum":  "",
+		"c/c.txt": "not a source\n",
+	})
+	mainModule := &Module{Path: "example.com/m", Main: true, Dir: dir, GoMod: filepath.Join(dir, "go.mod")}
+	newGraph := func() map[string]*Package {
+		return map[string]*Package{
+			"example.com/m/a": {ImportPath: "example.com/m/a", Dir: filepath.Join(dir, "a"), GoFiles: []string{"a.go"}, Module: mainModule},
+			"example.com/m/b": {ImportPath: "example.com/m/b", Dir: filepath.Join(dir, "b"), GoFiles: []string{"b.go"}, Module: mainModule,
+				Imports: []string{"example.com/m/a", "unsafe"}},
+			"strings": {ImportPath: "strings", Dir: filepath.Join(dir, "goroot", "strings"), GoFiles: []string{"missing.go"}, Standard: true},
+		}
+	}
+	keys := func(t *testing.T, config *BuildConfig) map[string]string {
+		t.Helper()
+		fake := NewFakeToolchain()
+		fake.EnvValues = map[string]string{"GOVERSION": "go1.22.0", "GOROOT": "/goroot", "GOOS": "linux", "GOARCH": "amd64"}
+		config.Toolchain = fake
+		config.WorkDir = dir
+		config.TargetDir = filepath.Join(dir, "target")
+		graph := newGraph()
+		keyer, err := newCacheKeyer(context.Background(), config, graph)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := map[string]string{}
+		for importPath, pkg := range graph {
+			if keys[importPath], err = keyer.key(pkg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(fake.Calls) != 1 || fake.Calls[0].Subcommand != "env" {
+			t.Fatalf("fake toolchain calls = %+v, want a single go env", fake.Calls)
+		}
+		return keys
+	}
+
+	base := keys(t, &BuildConfig{})
+	if again := keys(t, &BuildConfig{}); again["example.com/m/b"] != base["example.com/m/b"] {
+		t.Errorf("key changed without any change: %s, then %s", base["example.com/m/b"], again["example.com/m/b"])
+	}
+	tests := []struct {
+		name    string
+		config  *BuildConfig
+		change  map[string]string // files written before computing the keys
+		changed map[string]bool   // packages whose key must change
+	}{
+		{name: "env", config: &BuildConfig{BuildEnv: []string{"CGO_ENABLED=0"}},
+			changed: map[string]bool{"example.com/m/a": true, "example.com/m/b": true, "strings": true}},
+		{name: "flags", config: &BuildConfig{ExtraBuildFlags: []string{"-gcflags=-N"}},
+			changed: map[string]bool{"example.com/m/a": true, "example.com/m/b": true, "strings": true}},
+		{name: "import", config: &BuildConfig{}, change: map[string]string{"a/a.go": "package a\n\nvar V int\n"},
+			changed: map[string]bool{"example.com/m/a": true, "example.com/m/b": true}},
+		{name: "source", config: &BuildConfig{}, change: map[string]string{"b/b.go": "package b\n"},
+			changed: map[string]bool{"example.com/m/b": true}},
+		{name: "go.sum", config: &BuildConfig{}, change: map[string]string{"go.sum": "example.com/x v1.0.0 h1:x=\n"},
+			changed: map[string]bool{"example.com/m/a": true, "example.com/m/b": true}},
+		{name: "other file", config: &BuildConfig{}, change: map[string]string{"c/c.txt": "still not a source\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originals := map[string]string{}
+			for name := range tt.change {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				originals[name] = string(data)
+			}
+			writeFiles(t, dir, tt.change)
+			defer writeFiles(t, dir, originals)
+
+			got := keys(t, tt.config)
+			for importPath, key := range base {
+				if changed := got[importPath] != key; changed != tt.changed[importPath] {
+					t.Errorf("key of %s changed = %t, want %t", importPath, changed, tt.changed[importPath])
+				}
+			}
+		})
+	}
+}
+
+// archiveToolchain writes the -o target of every go build and counts the builds.
+type archiveToolchain struct {
+	*FakeToolchain
+	builds int
+}
+
+func (t *archiveToolchain) Build(ctx context.Context, inv *Invocation) ([]byte, error) {
+	t.builds++
+	return nil, os.WriteFile(outputPath(inv), []byte("archive"), 0644)
+}
+
+func TestCachedBuild(t *testing.T) {
+	for _, disableCache := range []bool{false, true} {
+		dir := t.TempDir()
+		toolchain := &archiveToolchain{FakeToolchain: NewFakeToolchain()}
+		config := &BuildConfig{
+			BuildPaths:   []string{"example.com/m/p"},
+			WorkDir:      dir,
+			TargetDir:    dir,
+			TargetPath:   filepath.Join(dir, "p.a"),
+			DisableCache: disableCache,
+			Toolchain:    toolchain,
+		}
+		for i := 0; i < 2; i++ {
+			cached, err := cachedBuild(context.Background(), config, "0123456789abcdef")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := i == 1 && !disableCache; cached != want {
+				t.Errorf("DisableCache=%v build %d: cached = %v, want %v", disableCache, i, cached, want)
+			}
+		}
+		if want := map[bool]int{false: 1, true: 2}[disableCache]; toolchain.builds != want {
+			t.Errorf("DisableCache=%v: go build ran %d times, want %d", disableCache, toolchain.builds, want)
+		}
+		entries, _ := os.ReadDir(config.cacheDir())
+		if disableCache && len(entries) != 0 {
+			t.Errorf("DisableCache=true wrote %d entries into the build cache", len(entries))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type CgoMode int
+
+const (
+	CgoReject CgoMode = iota // fail before building when a non-standard package uses cgo
+	CgoBundle                // extract the host objects of cgo packages next to their archive
+)
+
+type CgoObjects struct {
+	ImportPath string   // cgo package
+	Dir        string   // directory holding the extracted host objects
+	Objects    []string // host object files compiled by the C toolchain, in archive order
+	LDFLAGS    []string // linker flags from #cgo LDFLAGS and pkg-config, which goloader does not apply
+	Libraries  []string // libraries named by LDFLAGS, which must be provided by the host process
+}
+
+type CgoError struct {
+	ImportPath string   // cgo package
+	CgoFiles   []string // files importing "C"
+	CFiles     []string // C, C++, Objective-C and Fortran files compiled with the package
+	LDFLAGS    []string // linker flags of the package
+}
+
+func (e *CgoError) Error() string {
+	msg := fmt.Sprintf("package %s uses cgo (%s), goloader can not link the host objects compiled from its C code",
+		e.ImportPath, strings.Join(append(append([]string{}, e.CgoFiles...), e.CFiles...), ", "))
+	if len(e.LDFLAGS) > 0 {
+		msg += fmt.Sprintf(" nor the libraries of #cgo LDFLAGS: %s", strings.Join(e.LDFLAGS, " "))
+	}
+	return msg + "; link the package into the host executable or build with CgoBundle"
+}
+
+// usesCgo reports whether pkg needs host objects which are not part of the host executable,
+// cgo packages of the standard library are linked into every host built with cgo.
+func usesCgo(pkg *Package) bool {
+	return !pkg.Standard && len(pkg.CgoFiles) > 0
+}
+
+// checkCgo rejects pkg in CgoReject mode when it uses cgo.
+func (config *BuildConfig) checkCgo(pkg *Package) error {
+	if config.CgoMode != CgoReject || !usesCgo(pkg) {
+		return nil
+	}
+	var cFiles []string
+	for _, files := range [][]string{pkg.CFiles, pkg.CXXFiles, pkg.MFiles, pkg.FFiles, pkg.SysoFiles} {
+		cFiles = append(cFiles, files...)
+	}
+	return &CgoError{ImportPath: pkg.ImportPath, CgoFiles: pkg.CgoFiles, CFiles: cFiles, LDFLAGS: pkg.CgoLDFLAGS}
+}
+
+type arMember struct {
+	name string
+	data []byte
+}
+
+// readArchive returns the members of the ar archive built by go tool pack.
+func readArchive(path string) ([]arMember, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("!<arch>\n")) {
+		return nil, fmt.Errorf("%s is not an archive", path)
+	}
+	var members []arMember
+	for off := 8; off+60 <= len(data); {
+		header := data[off : off+60]
+		size, err := strconv.Atoi(strings.TrimSpace(string(header[48:58])))
+		if err != nil || size < 0 || off+60+size > len(data) {
+			return nil, fmt.Errorf("%s: malformed archive header at offset %d", path, off)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[:16])), "/")
+		members = append(members, arMember{name: name, data: data[off+60 : off+60+size]})
+		off += 60 + size + size%2
+	}
+	return members, nil
+}
+
+// isHostObject reports whether data is an ELF, Mach-O or COFF object file.
+func isHostObject(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	if bytes.HasPrefix(data, []byte("\x7fELF")) {
+		return true
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe:
+		return true
+	}
+	switch binary.LittleEndian.Uint16(data) {
+	case 0x14c, 0x8664, 0x1c4, 0xaa64:
+		return true
+	}
+	return false
+}
+
+// libraries returns the libraries named by linker flags, as -lname, library paths or frameworks.
+func libraries(ldflags []string) []string {
+	var libs []string
+	for i, ldflag := range ldflags {
+		switch {
+		case strings.HasPrefix(ldflag, "-l"):
+			libs = append(libs, ldflag)
+		case ldflag == "-framework" && i+1 < len(ldflags):
+			libs = append(libs, "-framework "+ldflags[i+1])
+		case !strings.HasPrefix(ldflag, "-") && (strings.HasSuffix(ldflag, ".a") || strings.HasSuffix(ldflag, ".so") ||
+			strings.Contains(ldflag, ".so.") || strings.HasSuffix(ldflag, ".dylib") || strings.HasSuffix(ldflag, ".lib")):
+			libs = append(libs, ldflag)
+		}
+	}
+	return libs
+}
+
+// bundleCgo extracts the host objects of the cgo package archive into a directory next to it.
+func bundleCgo(archive *builtArchive) (*CgoObjects, error) {
+	members, err := readArchive(archive.path)
+	if err != nil {
+		return nil, err
+	}
+	objects := &CgoObjects{
+		ImportPath: archive.pkg.ImportPath,
+		Dir:        strings.TrimSuffix(archive.path, ".a") + ".cgo",
+		LDFLAGS:    archive.pkg.CgoLDFLAGS,
+		Libraries:  libraries(archive.pkg.CgoLDFLAGS),
+	}
+	if err = os.RemoveAll(objects.Dir); err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if !isHostObject(member.data) {
+			continue
+		}
+		if err = os.MkdirAll(objects.Dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(objects.Dir, filepath.Base(member.name))
+		if err = os.WriteFile(path, member.data, 0644); err != nil {
+			return nil, fmt.Errorf("could not extract host object %s of %s: %w", member.name, archive.path, err)
+		}
+		objects.Objects = append(objects.Objects, path)
+	}
+	return objects, nil
+}
+
+// bundleCgoObjects extracts the host objects of every cgo archive in CgoBundle mode.
+func bundleCgoObjects(config *BuildConfig, archives []*builtArchive) ([]*CgoObjects, error) {
+	if config.CgoMode != CgoBundle {
+		return nil, nil
+	}
+	var bundles []*CgoObjects
+	for _, archive := range archives {
+		if !usesCgo(archive.pkg) {
+			continue
+		}
+		objects, err := bundleCgo(archive)
+		if err != nil {
+			return nil, err
+		}
+		archive.cgo = objects
+		bundles = append(bundles, objects)
+	}
+	return bundles, nil
+}
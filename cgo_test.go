@@ -0,0 +1,71 @@
+package goloaderbuilder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func arHeader(name string, size int) string {
+	return fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, size)
+}
+
+func TestReadArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []arMember
+		wantErr string
+	}{
+		{name: "empty", data: "!<arch>\n"},
+		{
+			name: "members",
+			data: "!<arch>\n" + arHeader("__.PKGDEF", 3) + "def\n" + arHeader("_go_.o", 4) + "code" + arHeader("_x001.o/", 2) + "\x7fE",
+			want: []arMember{{name: "__.PKGDEF", data: []byte("def")}, {name: "_go_.o", data: []byte("code")}, {name: "_x001.o", data: []byte("\x7fE")}},
+		},
+		{name: "not an archive", data: "\x7fELF", wantErr: "is not an archive"},
+		{name: "truncated", data: "!<arch>\n" + arHeader("_go_.o", 100) + "code", wantErr: "malformed archive header at offset 8"},
+		{name: "size", data: "!<arch>\n" + strings.Replace(arHeader("_go_.o", 0), "0         `", "x         `", 1), wantErr: "malformed archive header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "p.a")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readArchive(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readArchive() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readArchive() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCgo(t *testing.T) {
+	cgoPkg := &Package{ImportPath: "example.com/cg", CgoFiles: []string{"cg.go"}, CFiles: []string{"cg.c"}, CgoLDFLAGS: []string{"-lz"}}
+	stdPkg := &Package{ImportPath: "net", Standard: true, CgoFiles: []string{"cgo_unix.go"}}
+
+	var cgoErr *CgoError
+	if err := (&BuildConfig{}).checkCgo(cgoPkg); !errors.As(err, &cgoErr) || cgoErr.ImportPath != cgoPkg.ImportPath {
+		t.Errorf("default mode: checkCgo = %v, want a *CgoError for %s", err, cgoPkg.ImportPath)
+	}
+	if err := (&BuildConfig{}).checkCgo(stdPkg); err != nil {
+		t.Errorf("default mode: checkCgo(%s) = %v, want nil", stdPkg.ImportPath, err)
+	}
+	if err := (&BuildConfig{CgoMode: CgoBundle}).checkCgo(cgoPkg); err != nil {
+		t.Errorf("CgoBundle: checkCgo = %v, want nil", err)
+	}
+}
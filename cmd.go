@@ -2,45 +2,81 @@ package goloaderbuilder
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"os"
-	"os/exec"
 	"strings"
 )
 
 func GoModDownload(goCmd, workDir string, args ...string) error {
-	dlCmd := exec.Command(goCmd, append([]string{"mod", "download"}, args...)...)
-	dlCmd.Dir = workDir
-	output, err := dlCmd.CombinedOutput()
+	return GoModDownloadContext(context.Background(), goCmd, workDir, args...)
+}
+
+func GoModDownloadContext(ctx context.Context, goCmd, workDir string, args ...string) error {
+	return goModDownload(ctx, &ExecToolchain{GoBinary: goCmd}, workDir, args...)
+}
+
+func goModDownload(ctx context.Context, toolchain Toolchain, workDir string, args ...string) error {
+	_, err := toolchain.Mod(ctx, &Invocation{Dir: workDir, Args: append([]string{"download"}, args...)})
 	if err != nil {
-		return fmt.Errorf("failed to go mod download %s: %s", args, output)
+		return fmt.Errorf("failed to go mod download %s: %w", args, err)
 	}
 
-	tidyCmd := exec.Command(goCmd, "mod", "tidy")
-	output, err = tidyCmd.CombinedOutput()
+	_, err = toolchain.Mod(ctx, &Invocation{Dir: workDir, Args: []string{"tidy"}})
 	if err != nil {
-		return fmt.Errorf("failed to go mod tidy: %s", output)
+		return fmt.Errorf("failed to go mod tidy: %w", err)
 	}
 	return nil
 }
 
 func GoGet(goCmd, packagePath, workDir string) error {
-	goGetCmd := exec.Command(goCmd, "get", packagePath)
-	goGetCmd.Dir = workDir
-	output, err := goGetCmd.CombinedOutput()
+	return GoGetContext(context.Background(), goCmd, packagePath, workDir)
+}
+
+func GoGetContext(ctx context.Context, goCmd, packagePath, workDir string) error {
+	return goGet(ctx, &ExecToolchain{GoBinary: goCmd}, packagePath, workDir)
+}
+
+func goGet(ctx context.Context, toolchain Toolchain, packagePath, workDir string) error {
+	_, err := toolchain.Get(ctx, &Invocation{Dir: workDir, Args: []string{packagePath}})
 	if err != nil {
-		return fmt.Errorf("failed to go get %s: %s", packagePath, output)
+		return fmt.Errorf("failed to go get %s: %w", packagePath, err)
 	}
 	return nil
 }
 
+func GoEnv(goCmd string, keys ...string) (map[string]string, error) {
+	return GoEnvContext(context.Background(), goCmd, keys...)
+}
+
+func GoEnvContext(ctx context.Context, goCmd string, keys ...string) (map[string]string, error) {
+	return goEnv(ctx, &ExecToolchain{GoBinary: goCmd}, "", nil, keys...)
+}
+
+func goEnv(ctx context.Context, toolchain Toolchain, workDir string, env []string, keys ...string) (map[string]string, error) {
+	output, err := toolchain.Env(ctx, &Invocation{Dir: workDir, Env: env, Args: append([]string{"-json"}, keys...)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to go env %s: %w", keys, err)
+	}
+	values := map[string]string{}
+	if err = json.Unmarshal(output, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode response of 'go env -json': %w", err)
+	}
+	return values, nil
+}
+
 func GoListStd(goCmd string) map[string]struct{} {
+	return GoListStdContext(context.Background(), goCmd)
+}
+
+func GoListStdContext(ctx context.Context, goCmd string) map[string]struct{} {
+	return goListStd(ctx, &ExecToolchain{GoBinary: goCmd})
+}
+
+func goListStd(ctx context.Context, toolchain Toolchain) map[string]struct{} {
 	stdLibPkgs := map[string]struct{}{}
-	cmd := exec.Command(goCmd, "list", "std")
-	output, err := cmd.CombinedOutput()
+	output, err := toolchain.List(ctx, &Invocation{Args: []string{"std"}})
 	if err != nil {
 		log.Printf("goloaderbuilder failed to list std packages: %s\n", err)
 		return nil
@@ -51,64 +87,63 @@ func GoListStd(goCmd string) map[string]struct{} {
 	return stdLibPkgs
 }
 
+// GoList lists the package at absPath. targetPath is not used anymore, the listing used to be
+// written next to it.
 func GoList(goCmd, absPath, workDir, targetPath string) (*Package, error) {
-	goPath := os.Getenv("GOPATH")
-	targetPath = strings.Trim(targetPath, ".a") + ".json"
-	if !strings.HasPrefix(absPath, goPath) {
-		if _, err := os.Stat(targetPath); err == nil {
-			f, err := os.Open(targetPath)
-			if err != nil {
-				return nil, err
-			}
-			pkg := Package{}
-			err = json.NewDecoder(io.Reader(f)).Decode(&pkg)
-			if err != nil {
-				return nil, fmt.Errorf("failed to decode response of 'go list -json %s': %w\n", absPath, err)
-			}
-			if len(pkg.GoFiles)+len(pkg.CgoFiles) == 0 {
-				return nil, fmt.Errorf("no Go files found in directory %s", absPath)
-			}
-			return &pkg, nil
-		}
-	}
+	return GoListContext(context.Background(), goCmd, absPath, workDir, targetPath)
+}
 
-	golistCmd := exec.Command(goCmd, "list", "-json", absPath)
-	golistCmd.Dir = workDir
-	output, err := golistCmd.StdoutPipe()
-	stdErrBuf := &bytes.Buffer{}
-	golistCmd.Stderr = io.MultiWriter(os.Stderr, stdErrBuf)
-	if err != nil {
-		panic(err)
-	}
-	listDec := json.NewDecoder(output)
-	err = golistCmd.Start()
+func GoListContext(ctx context.Context, goCmd, absPath, workDir, targetPath string) (*Package, error) {
+	return goList(ctx, &ExecToolchain{GoBinary: goCmd}, absPath, workDir)
+}
+
+func goList(ctx context.Context, toolchain Toolchain, absPath, workDir string) (*Package, error) {
+	output, err := toolchain.List(ctx, &Invocation{Dir: workDir, Args: []string{"-json", absPath}})
 	if err != nil {
-		return nil, fmt.Errorf("failed to start 'go list -json %s': %w\nstderr:\n%s", absPath, err, stdErrBuf.String())
+		return nil, fmt.Errorf("failed to run 'go list -json %s': %w", absPath, err)
 	}
 	pkg := Package{}
-	err = listDec.Decode(&pkg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode response of 'go list -json %s': %w\nstderr:\n%s", absPath, err, stdErrBuf.String())
-	}
-	err = golistCmd.Wait()
+	err = json.Unmarshal(output, &pkg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to wait for 'go list -json %s': %w\nstderr:\n%s", absPath, err, stdErrBuf.String())
+		return nil, fmt.Errorf("failed to decode response of 'go list -json %s': %w", absPath, err)
 	}
 
 	if len(pkg.GoFiles)+len(pkg.CgoFiles) == 0 {
 		return nil, fmt.Errorf("no Go files found in directory %s", absPath)
 	}
+	return &pkg, nil
+}
+
+func GoListDeps(goCmd, workDir string, patterns ...string) (map[string]*Package, error) {
+	return GoListDepsContext(context.Background(), goCmd, workDir, patterns...)
+}
+
+func GoListDepsContext(ctx context.Context, goCmd, workDir string, patterns ...string) (map[string]*Package, error) {
+	graph, _, err := goListDeps(ctx, &ExecToolchain{GoBinary: goCmd}, workDir, nil, nil, patterns...)
+	return graph, err
+}
 
-	f, err := os.Create(targetPath)
-	defer f.Close()
+// goListDeps lists patterns and all their dependencies with a single 'go list -e -deps -json',
+// the returned order has every package after its dependencies. Errors loading a package are
+// reported in its Error and DepsErrors fields.
+func goListDeps(ctx context.Context, toolchain Toolchain, workDir string, env, flags []string, patterns ...string) (map[string]*Package, []string, error) {
+	args := append([]string{"-e", "-deps", "-json"}, flags...)
+	output, err := toolchain.List(ctx, &Invocation{Dir: workDir, Env: env, Args: append(args, patterns...)})
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("failed to run 'go list -deps -json %s': %w", strings.Join(patterns, " "), err)
 	}
-	writer := io.Writer(f)
-	encoder := json.NewEncoder(writer)
-	err = encoder.Encode(pkg)
-	if err != nil {
-		return nil, err
+	graph := map[string]*Package{}
+	order := []string{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		pkg := &Package{}
+		if err = decoder.Decode(pkg); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode response of 'go list -deps -json %s': %w", strings.Join(patterns, " "), err)
+		}
+		if _, ok := graph[pkg.ImportPath]; !ok {
+			order = append(order, pkg.ImportPath)
+		}
+		graph[pkg.ImportPath] = pkg
 	}
-	return &pkg, nil
+	return graph, order, nil
 }
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkujhd/goloader"
+	"github.com/pkujhd/goloaderbuilder"
+)
+
+// configFlags are the flags of the commands building or listing packages.
+type configFlags struct {
+	goBinary     string
+	env          stringsFlag
+	buildFlags   stringsFlag
+	workDir      string
+	keepWorkDir  bool
+	targetDir    string
+	pkgPath      string
+	debug        bool
+	dynlink      string
+	goos         string
+	goarch       string
+	microarch    string
+	timeout      time.Duration
+	concurrency  int
+	failFast     bool
+	cacheDir     string
+	noCache      bool
+	manifest     string
+	resolve      string
+	offline      bool
+	mirror       string
+	mod          string
+	goWork       string
+	hermetic     bool
+	goCache      string
+	goModCache   string
+	cgoBundle    bool
+	mainPathHash bool
+	mainEntry    string
+	pinHost      bool
+	inheritHost  bool
+	skipHost     bool
+}
+
+var dynlinkModes = map[string]goloaderbuilder.DynlinkMode{
+	"auto": goloaderbuilder.DynlinkAuto,
+	"on":   goloaderbuilder.DynlinkOn,
+	"off":  goloaderbuilder.DynlinkOff,
+}
+
+var resolveModes = map[string]goloaderbuilder.ResolveMode{
+	"modify":  goloaderbuilder.ResolveModify,
+	"modfile": goloaderbuilder.ResolveModfile,
+	"strict":  goloaderbuilder.ResolveStrict,
+}
+
+func addConfigFlags(cmd *command) *configFlags {
+	f := &configFlags{}
+	fs := cmd.flags
+	fs.StringVar(&f.goBinary, "go", "go", "go binary")
+	fs.Var(&f.env, "env", "environment variable KEY=VALUE of every go command, may be repeated")
+	fs.Var(&f.buildFlags, "build-flag", "go build flag, such as -tags=netgo, may be repeated")
+	fs.StringVar(&f.workDir, "work-dir", "", "directory go commands run in, defaults to a new directory in the current one")
+	fs.BoolVar(&f.keepWorkDir, "keep-work-dir", false, "keep the work directory and generated sources")
+	fs.StringVar(&f.targetDir, "target-dir", "target", "directory of the archives")
+	fs.StringVar(&f.pkgPath, "pkg-path", "", "package path of the root package, derived from its import path if empty")
+	fs.BoolVar(&f.debug, "debug", false, "print the output of go build")
+	fs.StringVar(&f.dynlink, "dynlink", "auto", "position independent code: auto (on platforms which require it), on or off")
+	fs.StringVar(&f.goos, "goos", "", "target GOOS")
+	fs.StringVar(&f.goarch, "goarch", "", "target GOARCH")
+	fs.StringVar(&f.microarch, "microarch", "", "target microarchitecture, such as a GOAMD64 or GOARM64 value")
+	fs.DurationVar(&f.timeout, "timeout", 0, "timeout of every go command")
+	fs.IntVar(&f.concurrency, "concurrency", 0, "maximum number of concurrent dependency builds, defaults to the number of CPUs")
+	fs.BoolVar(&f.failFast, "fail-fast", false, "stop building dependencies on the first failure")
+	fs.StringVar(&f.cacheDir, "cache-dir", "", "archive cache directory, defaults to <target-dir>/.cache")
+	fs.BoolVar(&f.noCache, "no-cache", false, "rebuild every archive")
+	fs.StringVar(&f.manifest, "manifest", "", "manifest path, defaults next to the root archive")
+	fs.StringVar(&f.resolve, "resolve", "modfile", "how missing dependencies are resolved: modfile, modify or strict")
+	fs.BoolVar(&f.offline, "offline", false, "never access the network, use the module cache or -mirror")
+	fs.StringVar(&f.mirror, "mirror", "", "module mirror directory used in offline mode")
+	fs.StringVar(&f.mod, "mod", "", "module download mode: mod, readonly or vendor")
+	fs.StringVar(&f.goWork, "gowork", "", "go.work file of a workspace, off disables workspace mode")
+	fs.BoolVar(&f.hermetic, "hermetic", false, "only pass PATH, HOME and temp dir variables of the environment to go commands")
+	fs.StringVar(&f.goCache, "gocache", "", "GOCACHE of go commands")
+	fs.StringVar(&f.goModCache, "gomodcache", "", "GOMODCACHE of go commands")
+	fs.BoolVar(&f.cgoBundle, "cgo-bundle", false, "extract the host objects of cgo packages instead of failing")
+	fs.BoolVar(&f.mainPathHash, "main-path-hash", false, "derive the package path of a main package from a hash of its sources")
+	fs.StringVar(&f.mainEntry, "main-entry", "", "exported name main.main is renamed to, defaults to "+goloaderbuilder.DefaultMainEntry)
+	fs.BoolVar(&f.pinHost, "pin-host-modules", false, "build with the module versions of the -exe host executable, in a copy of go.mod")
+	fs.BoolVar(&f.inheritHost, "inherit-host-settings", false, "build with the -tags, GOEXPERIMENT, -race and -trimpath settings of the -exe host executable")
+	fs.BoolVar(&f.skipHost, "skip-host-deps", false, "only build the dependencies providing symbols missing from the -exe host executable")
+	return f
+}
+
+// config returns the build config of the flags. The work directory is created when it is not set,
+// the returned function removes it unless it must be kept.
+func (f *configFlags) config(paths []string) (*goloaderbuilder.BuildConfig, func(), error) {
+	dynlinkMode, ok := dynlinkModes[f.dynlink]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown dynlink mode %q", f.dynlink)
+	}
+	resolveMode, ok := resolveModes[f.resolve]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown resolve mode %q", f.resolve)
+	}
+	config := &goloaderbuilder.BuildConfig{
+		GoBinary:             f.goBinary,
+		ExtraBuildFlags:      f.buildFlags,
+		BuildEnv:             f.env,
+		BuildPaths:           paths,
+		PkgPath:              f.pkgPath,
+		TargetDir:            f.targetDir,
+		WorkDir:              f.workDir,
+		KeepWorkDir:          f.keepWorkDir,
+		DebugLog:             f.debug,
+		Dynlink:              dynlinkMode,
+		Timeout:              f.timeout,
+		Concurrency:          f.concurrency,
+		FailFast:             f.failFast,
+		CacheDir:             f.cacheDir,
+		DisableCache:         f.noCache,
+		ManifestPath:         f.manifest,
+		GOOS:                 f.goos,
+		GOARCH:               f.goarch,
+		Microarch:            f.microarch,
+		ResolveMode:          resolveMode,
+		Offline:              f.offline,
+		MirrorDir:            f.mirror,
+		ModMode:              goloaderbuilder.ModMode(f.mod),
+		GoWork:               f.goWork,
+		GoCache:              f.goCache,
+		GoModCache:           f.goModCache,
+		MainEntry:            f.mainEntry,
+		PinHostModules:       f.pinHost,
+		InheritHostSettings:  f.inheritHost,
+		SkipHostDependencies: f.skipHost,
+	}
+	if f.hermetic {
+		config.EnvPolicy = goloaderbuilder.EnvHermetic
+	}
+	if f.cgoBundle {
+		config.CgoMode = goloaderbuilder.CgoBundle
+	}
+	if f.mainPathHash {
+		config.MainPathMode = goloaderbuilder.MainPathHash
+	}
+	cleanup := func() {}
+	if config.WorkDir == "" {
+		// the go command must run inside the module of the packages, the library removes its work dir
+		dir, err := os.MkdirTemp(".", ".goloaderbuilder-work-")
+		if err != nil {
+			return nil, nil, err
+		}
+		config.WorkDir = dir
+		if !config.KeepWorkDir {
+			cleanup = func() { os.RemoveAll(dir) }
+		}
+	}
+	return config, cleanup, nil
+}
+
+type dependency struct {
+	PkgPath string // package path passed to goloader
+	Path    string // archive path
+}
+
+type buildOutput struct {
+	PkgPath      string                              // package path of the root package
+	TargetPath   string                              // archive of the root package
+	Entry        string                              // runnable symbol of a main package or a test main
+	ManifestPath string                              // manifest of every archive
+	Dependencies []dependency                        // archives of the dependencies, in build order
+	Resolve      *goloaderbuilder.ResolveReport      `json:",omitempty"` // dependencies added to go.mod
+	Cgo          []*goloaderbuilder.CgoObjects       `json:",omitempty"` // host objects of cgo packages
+	HostModules  *goloaderbuilder.HostModuleReport   `json:",omitempty"` // module versions compared with -exe
+	Skipped      []goloaderbuilder.SkippedDependency `json:",omitempty"` // dependencies provided by -exe
+	Goloader     string                              `json:",omitempty"` // linked .goloader file, with -exe
+}
+
+func runBuild(cmd *command, args []string) int {
+	f := addConfigFlags(cmd)
+	exe := cmd.flags.String("exe", "", "host executable to link the archives for, writes a .goloader file loadable by it")
+	out := cmd.flags.String("o", "", "path of the .goloader file, defaults to <platform dir>/<pkg path>.goloader")
+	test := cmd.flags.Bool("test", false, "build the tests of the package, run them with 'run -test'")
+	if ok, code := cmd.parse(args); !ok {
+		return code
+	}
+	if cmd.flags.NArg() == 0 {
+		return cmd.usageError("no package directory or go files given")
+	}
+	config, cleanup, err := f.config(cmd.flags.Args())
+	if err != nil {
+		return cmd.usageError("%s", err)
+	}
+	defer cleanup()
+	config.Host = *exe
+
+	var result *goloaderbuilder.BuildResult
+	if *test {
+		result, err = goloaderbuilder.BuildTestPackage(config)
+	} else {
+		result, err = goloaderbuilder.BuildWithDependencies(config)
+	}
+	if err != nil {
+		return cmd.fail(err)
+	}
+	output := &buildOutput{
+		PkgPath:      result.PkgPath,
+		TargetPath:   result.TargetPath,
+		Entry:        result.Entry,
+		ManifestPath: result.ManifestPath,
+		Resolve:      result.Resolve,
+		Cgo:          result.Cgo,
+		HostModules:  result.HostModules,
+		Skipped:      result.Skipped,
+	}
+	for i := range result.DepFiles {
+		output.Dependencies = append(output.Dependencies, dependency{PkgPath: result.DepPkgPaths[i], Path: result.DepFiles[i]})
+	}
+	if *exe != "" {
+		output.Goloader = *out
+		if output.Goloader == "" {
+			output.Goloader = filepath.Join(config.PlatformDir(), result.PkgPath) + ".goloader"
+		}
+		if err = link(result, *exe, output.Goloader); err != nil {
+			return cmd.fail(err)
+		}
+		if err = goloaderbuilder.WriteLinkMetadata(config, result, *exe, output.Goloader); err != nil {
+			return cmd.fail(err)
+		}
+	}
+
+	cmd.print(output, func(w io.Writer) {
+		fmt.Fprintf(w, "%s\t%s\n", output.PkgPath, output.TargetPath)
+		fmt.Fprintf(w, "%d dependencies, manifest %s\n", len(output.Dependencies), output.ManifestPath)
+		for _, objects := range output.Cgo {
+			fmt.Fprintf(w, "cgo package %s: host objects %v, libraries %v\n", objects.ImportPath, objects.Objects, objects.Libraries)
+		}
+		printHostModules(w, output.HostModules)
+		for _, skipped := range output.Skipped {
+			fmt.Fprintf(w, "skipped %s: %s\n", skipped.ImportPath, skipped.Reason)
+		}
+		if output.Goloader != "" {
+			fmt.Fprintf(w, "linked %s\n", output.Goloader)
+		}
+		if output.Entry != "" {
+			fmt.Fprintf(w, "run entry %s\n", output.Entry)
+		}
+	})
+	return exitOK
+}
+
+// link resolves the symbols of the root archive against the host executable exe, reads the
+// dependencies providing the symbols the host lacks and serializes the linker into path.
+func link(result *goloaderbuilder.BuildResult, exe, path string) error {
+	symPtr := make(map[string]uintptr)
+	if err := goloader.RegSymbolWithPath(symPtr, exe); err != nil {
+		return fmt.Errorf("could not read symbols of %s: %w", exe, err)
+	}
+	linker, err := goloader.ReadObj(result.TargetPath, result.PkgPath)
+	if err != nil {
+		return err
+	}
+	if unresolved := goloader.UnresolvedSymbols(linker, symPtr); len(unresolved) > 0 {
+		if err = goloader.ReadDependPackages(linker, result.DepFiles, result.DepPkgPaths, unresolved, symPtr); err != nil {
+			return err
+		}
+		if unresolved = goloader.UnresolvedSymbols(linker, symPtr); len(unresolved) > 0 {
+			return fmt.Errorf("unresolved symbols: %v", unresolved)
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = goloader.Serialize(linker, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printHostModules(w io.Writer, report *goloaderbuilder.HostModuleReport) {
+	if report == nil {
+		return
+	}
+	for _, mismatch := range report.Mismatches {
+		fmt.Fprintf(w, "module %s: %s, host has %s\n", mismatch.Path, mismatch.Version, mismatch.HostVersion)
+	}
+	for _, pinned := range report.Pinned {
+		fmt.Fprintf(w, "pinned %s@%s in %s\n", pinned.Path, pinned.Version, report.ModFile)
+	}
+	for _, path := range report.Unpinned {
+		fmt.Fprintf(w, "could not pin replaced module %s\n", path)
+	}
+}
+
+type depOutput struct {
+	ImportPath string
+	Module     string `json:",omitempty"` // module path and version
+	Standard   bool   `json:",omitempty"`
+	CgoFiles   int    `json:",omitempty"`
+}
+
+func runDeps(cmd *command, args []string) int {
+	f := addConfigFlags(cmd)
+	exe := cmd.flags.String("exe", "", "host executable to compare the module versions of the dependencies with")
+	if ok, code := cmd.parse(args); !ok {
+		return code
+	}
+	if cmd.flags.NArg() == 0 {
+		return cmd.usageError("no package directory or go files given")
+	}
+	config, cleanup, err := f.config(cmd.flags.Args())
+	if err != nil {
+		return cmd.usageError("%s", err)
+	}
+	defer cleanup()
+	config.Host = *exe
+
+	pkg, deps, err := goloaderbuilder.ListDependencies(config)
+	if err != nil {
+		return cmd.fail(err)
+	}
+	output := struct {
+		ImportPath   string
+		Dependencies []depOutput
+		HostModules  []goloaderbuilder.ModuleMismatch `json:",omitempty"`
+	}{ImportPath: pkg.ImportPath}
+	if *exe != "" {
+		if output.HostModules, err = goloaderbuilder.CompareHostModules(*exe, deps); err != nil {
+			return cmd.fail(err)
+		}
+	}
+	for _, dep := range deps {
+		entry := depOutput{ImportPath: dep.ImportPath, Standard: dep.Standard, CgoFiles: len(dep.CgoFiles)}
+		if dep.Module != nil {
+			entry.Module = dep.Module.Path
+			if dep.Module.Version != "" {
+				entry.Module += "@" + dep.Module.Version
+			}
+		}
+		output.Dependencies = append(output.Dependencies, entry)
+	}
+	cmd.print(output, func(w io.Writer) {
+		for _, dep := range output.Dependencies {
+			switch {
+			case dep.Standard:
+				fmt.Fprintf(w, "%s\tstd\n", dep.ImportPath)
+			case dep.Module != "":
+				fmt.Fprintf(w, "%s\t%s\n", dep.ImportPath, dep.Module)
+			default:
+				fmt.Fprintf(w, "%s\n", dep.ImportPath)
+			}
+		}
+		for _, mismatch := range output.HostModules {
+			fmt.Fprintf(w, "module %s: %s, host has %s\n", mismatch.Path, mismatch.Version, mismatch.HostVersion)
+		}
+	})
+	return exitOK
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkujhd/goloaderbuilder"
+)
+
+func runClean(cmd *command, args []string) int {
+	targetDir := cmd.flags.String("target-dir", "target", "directory of the archives")
+	cacheDir := cmd.flags.String("cache-dir", "", "archive cache directory, defaults to <target-dir>/.cache")
+	goBinary := cmd.flags.String("go", "go", "go binary")
+	goos := cmd.flags.String("goos", "", "target GOOS")
+	goarch := cmd.flags.String("goarch", "", "target GOARCH")
+	microarch := cmd.flags.String("microarch", "", "target microarchitecture")
+	all := cmd.flags.Bool("all", false, "remove the whole target directory, with the outputs of every platform")
+	if ok, code := cmd.parse(args); !ok {
+		return code
+	}
+	if cmd.flags.NArg() != 0 {
+		return cmd.usageError("unexpected arguments %v", cmd.flags.Args())
+	}
+
+	var removed []string
+	if *all {
+		if _, err := os.Stat(*targetDir); err == nil {
+			if err = os.RemoveAll(*targetDir); err != nil {
+				return cmd.fail(err)
+			}
+			removed = append(removed, *targetDir)
+		}
+	} else {
+		config := &goloaderbuilder.BuildConfig{GoBinary: *goBinary, TargetDir: *targetDir, CacheDir: *cacheDir,
+			GOOS: *goos, GOARCH: *goarch, Microarch: *microarch}
+		var err error
+		if removed, err = goloaderbuilder.Clean(config); err != nil {
+			return cmd.fail(err)
+		}
+	}
+	cmd.print(struct{ Removed []string }{removed}, func(w io.Writer) {
+		for _, path := range removed {
+			fmt.Fprintf(w, "removed %s\n", path)
+		}
+	})
+	return exitOK
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/pkujhd/goloaderbuilder"
+)
+
+type check struct {
+	Name   string
+	Status string // ok, warn or fail
+	Detail string
+}
+
+// goloaderArchs are the architectures goloader can load code for.
+var goloaderArchs = map[string]bool{"amd64": true, "arm64": true, "386": true, "arm": true}
+
+func runDoctor(cmd *command, args []string) int {
+	goBinary := cmd.flags.String("go", "go", "go binary")
+	targetDir := cmd.flags.String("target-dir", "target", "directory of the archives")
+	if ok, code := cmd.parse(args); !ok {
+		return code
+	}
+	if cmd.flags.NArg() != 0 {
+		return cmd.usageError("unexpected arguments %v", cmd.flags.Args())
+	}
+
+	var checks []check
+	add := func(name, status, format string, a ...interface{}) {
+		checks = append(checks, check{Name: name, Status: status, Detail: fmt.Sprintf(format, a...)})
+	}
+	env, err := goloaderbuilder.GoEnv(*goBinary, "GOVERSION", "GOROOT", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS", "GOCACHE", "GOMODCACHE")
+	if err != nil {
+		add("go", "fail", "could not run %s env: %s", *goBinary, err)
+	} else {
+		add("go", "ok", "%s in %s", env["GOVERSION"], env["GOROOT"])
+		if env["GOVERSION"] != runtime.Version() {
+			add("go version", "warn", "archives of %s can not be loaded by this executable built with %s", env["GOVERSION"], runtime.Version())
+		} else {
+			add("go version", "ok", "same as this executable")
+		}
+		if !goloaderArchs[env["GOARCH"]] {
+			add("platform", "fail", "goloader does not support %s/%s", env["GOOS"], env["GOARCH"])
+		} else {
+			add("platform", "ok", "%s/%s", env["GOOS"], env["GOARCH"])
+		}
+		if env["CGO_ENABLED"] != "1" {
+			add("cgo", "warn", "CGO_ENABLED=%s, packages using cgo can not be built", env["CGO_ENABLED"])
+		} else {
+			add("cgo", "ok", "enabled")
+		}
+		if env["GOFLAGS"] != "" {
+			add("GOFLAGS", "warn", "%q applies to every go command of the builder", env["GOFLAGS"])
+		}
+		for _, key := range []string{"GOCACHE", "GOMODCACHE"} {
+			if env[key] == "" || env[key] == "off" {
+				add(key, "fail", "%s is not set", key)
+			} else if err = writable(env[key]); err != nil {
+				add(key, "warn", "%s", err)
+			} else {
+				add(key, "ok", "%s", env[key])
+			}
+		}
+	}
+	if err = writable(*targetDir); err != nil {
+		add("target dir", "fail", "%s", err)
+	} else {
+		add("target dir", "ok", "%s", *targetDir)
+	}
+
+	code := exitOK
+	for _, c := range checks {
+		if c.Status == "fail" {
+			code = exitFailure
+		}
+	}
+	cmd.print(struct{ Checks []check }{checks}, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, c := range checks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Status, c.Name, c.Detail)
+		}
+		tw.Flush()
+	})
+	return code
+}
+
+// writable creates dir if needed and reports whether files can be created in it.
+func writable(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".goloaderbuilder-doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkujhd/goloader"
+	"github.com/pkujhd/goloaderbuilder"
+)
+
+func runInspect(cmd *command, args []string) int {
+	symbols := cmd.flags.Bool("symbols", false, "list the symbols defined by a .goloader file")
+	host := cmd.flags.Bool("host", false, "list the symbols a .goloader file expects from the host")
+	if ok, code := cmd.parse(args); !ok {
+		return code
+	}
+	if cmd.flags.NArg() != 1 {
+		return cmd.usageError("expected one .goloader file or manifest")
+	}
+	if strings.HasSuffix(cmd.flags.Arg(0), ".goloader") {
+		return inspectGoloader(cmd, cmd.flags.Arg(0), *symbols, *host)
+	}
+	manifest, err := goloaderbuilder.ReadManifest(cmd.flags.Arg(0))
+	if err != nil {
+		return cmd.fail(err)
+	}
+	cmd.print(manifest, func(w io.Writer) {
+		fmt.Fprintf(w, "%s %s, created %s\n\n", manifest.GoVersion, manifest.Platform, manifest.Created.Format("2006-01-02 15:04:05 MST"))
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "PKGPATH\tMODULE\tSIZE\tCACHED\tARCHIVE\n")
+		for _, entry := range append([]goloaderbuilder.ManifestEntry{manifest.Root}, manifest.Dependencies...) {
+			module := entry.ModulePath
+			if entry.ModuleVersion != "" {
+				module += "@" + entry.ModuleVersion
+			}
+			if module == "" {
+				module = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%t\t%s\n", entry.PkgPath, module, entry.Size, entry.Cached, entry.Path)
+		}
+		tw.Flush()
+	})
+	return exitOK
+}
+
+func inspectGoloader(cmd *command, path string, symbols, host bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return cmd.fail(err)
+	}
+	linker, err := goloader.UnSerialize(f)
+	f.Close()
+	if err != nil {
+		return cmd.fail(fmt.Errorf("could not read %s: %w", path, err))
+	}
+	info, err := goloaderbuilder.InspectLinker(linker, path)
+	if err != nil {
+		return cmd.fail(err)
+	}
+	cmd.print(info, func(w io.Writer) {
+		if metadata := info.Metadata; metadata != nil {
+			fmt.Fprintf(w, "%s %s, linked %s for %s\n", metadata.GoVersion, metadata.Platform, metadata.Created.Format("2006-01-02 15:04:05 MST"), metadata.Host)
+			fmt.Fprintf(w, "package %s, entry %s, build flags %v\n", metadata.PkgPath, metadata.Entry, metadata.BuildFlags)
+			fmt.Fprintf(w, "manifest %s\n", metadata.ManifestPath)
+			if host := metadata.HostFingerprint; host != nil {
+				fmt.Fprintf(w, "host build ID %s, %s %s/%s, %d modules\n", host.BuildID, host.GoVersion, host.GOOS, host.GOARCH, len(host.Modules))
+			}
+		} else {
+			fmt.Fprintf(w, "no build metadata recorded\n")
+		}
+		fmt.Fprintf(w, "%d symbols, %d exported, %d expected from the host\n\n", len(info.Symbols), len(info.Exported), len(info.Host))
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "PKGPATH\tSYMBOLS\tCODE\tDATA\n")
+		for _, pkg := range info.Packages {
+			pkgPath := pkg.PkgPath
+			if pkgPath == "" {
+				pkgPath = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", pkgPath, pkg.Symbols, pkg.CodeSize, pkg.DataSize)
+		}
+		tw.Flush()
+		if symbols {
+			fmt.Fprintf(w, "\n")
+			tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "SYMBOL\tKIND\tSIZE\tEXPORTED\n")
+			for _, symbol := range info.Symbols {
+				kind := "data"
+				if symbol.Code {
+					kind = "code"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%t\n", symbol.Name, kind, symbol.Size, symbol.Exported)
+			}
+			tw.Flush()
+		}
+		if host {
+			fmt.Fprintf(w, "\nexpected from the host:\n")
+			for _, name := range info.Host {
+				fmt.Fprintf(w, "\t%s\n", name)
+			}
+		}
+	})
+	return exitOK
+}
@@ -0,0 +1,133 @@
+// Command goloaderbuilder builds go packages into archives loadable by goloader, links them
+// against a host executable and runs them.
+//
+//	goloaderbuilder <command> [flags] [arguments]
+//
+// Every command accepts -json to print a machine-readable result on stdout. The exit code is 0
+// on success, 1 when the command failed and 2 on usage errors.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(cmd *command, args []string) int
+	flags   *flag.FlagSet
+	json    bool
+}
+
+var commands = []*command{
+	{name: "build", args: "[flags] <package dir | go files>", summary: "build a package and its dependencies, and link them for a host executable with -exe", run: runBuild},
+	{name: "deps", args: "[flags] <package dir | go files>", summary: "list the dependencies which are built for a package", run: runDeps},
+	{name: "inspect", args: "[flags] <file.goloader | manifest>", summary: "show the packages and symbols of a .goloader file, or the archives of a build manifest", run: runInspect},
+	{name: "clean", args: "[flags]", summary: "remove the archives of the target platform and the build cache", run: runClean},
+	{name: "run", args: "[flags] <file.goloader> [-- arguments]", summary: "load a linked .goloader file into this process and run its entry", run: runRun},
+	{name: "doctor", args: "[flags]", summary: "check the go toolchain and the target directory", run: runDoctor},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: goloaderbuilder <command> [flags] [arguments]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nrun 'goloaderbuilder <command> -help' for the flags of a command\n")
+}
+
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		usage(os.Stdout)
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.flags = flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+			cmd.flags.BoolVar(&cmd.json, "json", false, "print the result as JSON")
+			cmd.flags.Usage = func() {
+				fmt.Fprintf(cmd.flags.Output(), "usage: goloaderbuilder %s %s\n\n%s\n\nflags:\n", cmd.name, cmd.args, cmd.summary)
+				cmd.flags.PrintDefaults()
+			}
+			return cmd.run(cmd, args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "goloaderbuilder: unknown command %q\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
+
+// parse parses the flags of the command, it returns false with the exit code when the command must stop.
+func (cmd *command) parse(args []string) (bool, int) {
+	if err := cmd.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return false, exitOK
+		}
+		return false, exitUsage
+	}
+	return true, exitOK
+}
+
+func (cmd *command) usageError(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "goloaderbuilder %s: %s\n", cmd.name, fmt.Sprintf(format, a...))
+	cmd.flags.Usage()
+	return exitUsage
+}
+
+// warn reports a problem which does not fail the command on stderr, stdout stays valid JSON in JSON mode.
+func (cmd *command) warn(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "goloaderbuilder %s: warning: %s\n", cmd.name, fmt.Sprintf(format, a...))
+}
+
+// fail reports err as the result of the command, as {"Error": "..."} on stdout in JSON mode.
+func (cmd *command) fail(err error) int {
+	if cmd.json {
+		cmd.print(struct{ Error string }{err.Error()}, nil)
+	} else {
+		fmt.Fprintf(os.Stderr, "goloaderbuilder %s: %s\n", cmd.name, err)
+	}
+	return exitFailure
+}
+
+// print writes the result as indented JSON in JSON mode, and with text otherwise.
+func (cmd *command) print(result interface{}, text func(w io.Writer)) {
+	if cmd.json || text == nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+		return
+	}
+	text(os.Stdout)
+}
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/pkujhd/goloader"
+	"github.com/pkujhd/goloaderbuilder"
+)
+
+// findEntry returns the only symbol of the module named suffix, such as the GoloaderMain of a main package.
+func findEntry(syms map[string]uintptr, suffix string) (string, error) {
+	var found []string
+	for name := range syms {
+		if strings.HasSuffix(name, "."+suffix) {
+			found = append(found, name)
+		}
+	}
+	sort.Strings(found)
+	if len(found) != 1 {
+		return "", fmt.Errorf("found %d %s symbols %v, select one with -entry", len(found), suffix, found)
+	}
+	return found[0], nil
+}
+
+func runRun(cmd *command, args []string) int {
+	entry := cmd.flags.String("entry", "", "symbol to run, defaults to the only "+goloaderbuilder.DefaultMainEntry+" or "+goloaderbuilder.TestMainEntry+" symbol")
+	test := cmd.flags.String("test", "", "import path of the package whose tests were built with 'build -test', remaining arguments are test flags")
+	force := cmd.flags.Bool("force", false, "load the file even when it was linked for another host executable than goloaderbuilder")
+	if ok, code := cmd.parse(args); !ok {
+		return code
+	}
+	if cmd.flags.NArg() == 0 {
+		return cmd.usageError("no .goloader file given")
+	}
+	path, runArgs := cmd.flags.Arg(0), cmd.flags.Args()[1:]
+
+	if !*force {
+		exe, err := os.Executable()
+		if err != nil {
+			return cmd.fail(err)
+		}
+		verified, err := goloaderbuilder.CheckHost(path, exe)
+		if err != nil {
+			return cmd.fail(err)
+		}
+		if !verified {
+			cmd.warn("%s has no recorded host fingerprint, the executable it was linked for is unknown", path)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cmd.fail(err)
+	}
+	linker, err := goloader.UnSerialize(f)
+	f.Close()
+	if err != nil {
+		return cmd.fail(fmt.Errorf("could not read %s: %w", path, err))
+	}
+	symPtr := make(map[string]uintptr)
+	if err = goloader.RegSymbol(symPtr); err != nil {
+		return cmd.fail(fmt.Errorf("could not read symbols of this executable: %w", err))
+	}
+	codeModule, err := goloader.Load(linker, symPtr)
+	if err != nil {
+		return cmd.fail(fmt.Errorf("could not load %s: %w", path, err))
+	}
+	defer codeModule.Unload()
+
+	name := *entry
+	if name == "" {
+		suffix := goloaderbuilder.DefaultMainEntry
+		if *test != "" {
+			suffix = goloaderbuilder.TestMainEntry
+		}
+		if name, err = findEntry(codeModule.Syms, suffix); err != nil {
+			return cmd.fail(err)
+		}
+	}
+	entryPtr := codeModule.Syms[name]
+	if entryPtr == 0 {
+		return cmd.fail(fmt.Errorf("symbol %s not found in %s", name, path))
+	}
+	funcPtrContainer := (uintptr)(unsafe.Pointer(&entryPtr))
+
+	if *test != "" {
+		testMain := *(*func([]string) int)(unsafe.Pointer(&funcPtrContainer))
+		if !cmd.json {
+			return testMain(runArgs)
+		}
+		exitCode, err := goloaderbuilder.RunTestJSON(os.Stdout, *test, runArgs, testMain)
+		if err != nil {
+			return cmd.fail(err)
+		}
+		return exitCode
+	}
+
+	runFunc := *(*func())(unsafe.Pointer(&funcPtrContainer))
+	osArgs := os.Args
+	os.Args = append([]string{path}, runArgs...)
+	runFunc()
+	os.Args = osArgs
+	os.Stdout.Sync()
+	if cmd.json {
+		cmd.print(struct{ Entry string }{name}, nil)
+	}
+	return exitOK
+}
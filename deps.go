@@ -0,0 +1,272 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type BuildResult struct {
+	Package      *Package            // root package
+	TargetPath   string              // archive of the root package
+	PkgPath      string              // package path of the root package
+	DepFiles     []string            // archives of the dependencies, in build order
+	DepPkgPaths  []string            // package paths of the dependencies, in the same order as DepFiles
+	ManifestPath string              // manifest describing every produced archive
+	Resolve      *ResolveReport      // dependencies resolved while listing the root package, nil if none were missing
+	Entry        string              // runnable symbol, main.main renamed to MainEntry, empty unless the root package is main
+	Cgo          []*CgoObjects       // host objects of the cgo packages among the root and its dependencies, with CgoBundle
+	HostModules  *HostModuleReport   // module versions compared with the host executable, nil unless Host is set
+	Skipped      []SkippedDependency // dependencies provided by the host executable, with SkipHostDependencies
+}
+
+type builtArchive struct {
+	config  *BuildConfig // config the archive was built with
+	pkg     *Package
+	pkgPath string
+	path    string
+	cached  bool
+	cgo     *CgoObjects
+}
+
+func BuildWithDependencies(config *BuildConfig) (*BuildResult, error) {
+	return BuildWithDependenciesContext(context.Background(), config)
+}
+
+func BuildWithDependenciesContext(ctx context.Context, config *BuildConfig) (*BuildResult, error) {
+	// the dependencies are listed in the work directory, it is removed once they are built
+	if !config.KeepWorkDir {
+		defer os.RemoveAll(config.WorkDir)
+	}
+
+	var pkg *Package
+	var cached bool
+	var err error
+	if len(config.BuildPaths) > 0 && strings.HasSuffix(config.BuildPaths[0], ".go") {
+		pkg, cached, err = buildGoFiles(ctx, config)
+	} else {
+		pkg, cached, err = buildGoPackage(ctx, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buildResult(ctx, config, pkg, cached)
+}
+
+// buildResult builds the dependencies of the root package pkg and writes the manifest.
+func buildResult(ctx context.Context, config *BuildConfig, pkg *Package, cached bool) (*BuildResult, error) {
+	deps, err := buildDependencies(ctx, config, pkg, []string{config.TargetPath})
+	if err != nil {
+		return nil, err
+	}
+	root := &builtArchive{config: config, pkg: pkg, pkgPath: config.PkgPath, path: config.TargetPath, cached: cached}
+	return writeResult(config, root, deps)
+}
+
+// writeResult bundles the host objects of cgo packages and writes the manifest of root and deps.
+func writeResult(config *BuildConfig, root *builtArchive, deps []*builtArchive) (*BuildResult, error) {
+	result := &BuildResult{
+		Package:     root.pkg,
+		TargetPath:  root.path,
+		PkgPath:     root.pkgPath,
+		Resolve:     config.resolve,
+		Entry:       config.entry,
+		HostModules: config.hostModules,
+		Skipped:     config.skipped,
+	}
+	for _, dep := range deps {
+		result.DepFiles = append(result.DepFiles, dep.path)
+		result.DepPkgPaths = append(result.DepPkgPaths, dep.pkgPath)
+	}
+
+	var err error
+	if result.Cgo, err = bundleCgoObjects(config, append([]*builtArchive{root}, deps...)); err != nil {
+		return nil, err
+	}
+	manifest, err := newManifest(config, root, deps)
+	if err != nil {
+		return nil, err
+	}
+	result.ManifestPath = config.manifestPath()
+	if err = manifest.write(result.ManifestPath); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func BuildDependencies(config *BuildConfig, pkg *Package) ([]string, []string, error) {
+	return BuildDependenciesContext(context.Background(), config, pkg)
+}
+
+// BuildDependenciesContext builds an archive for every package transitively imported by pkg,
+// runtime included, and returns the archive paths with their package paths in dependency order.
+// With SkipHostDependencies only the packages providing symbols which the archive at TargetPath
+// needs and Host lacks are built.
+func BuildDependenciesContext(ctx context.Context, config *BuildConfig, pkg *Package) ([]string, []string, error) {
+	deps, err := buildDependencies(ctx, config, pkg, []string{config.TargetPath})
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]string, 0)
+	pkgPaths := make([]string, 0)
+	for _, dep := range deps {
+		files = append(files, dep.path)
+		pkgPaths = append(pkgPaths, dep.pkgPath)
+	}
+	return files, pkgPaths, nil
+}
+
+// buildDependencies builds the dependencies of pkg, with SkipHostDependencies only those needed by
+// the archives roots and the host executable does not provide.
+func buildDependencies(ctx context.Context, config *BuildConfig, pkg *Package, roots []string) ([]*builtArchive, error) {
+	caller := config
+	caller.skipped = nil
+	if config.SkipHostDependencies && config.Host == "" {
+		return nil, fmt.Errorf("SkipHostDependencies needs the host executable in Host")
+	}
+	depConfig := *config
+	depConfig.overlay = nil
+	config = &depConfig
+	graph, order, err := listDependencyGraph(ctx, config, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, importPkg := range order {
+		if err = config.checkCgo(graph[importPkg]); err != nil {
+			return nil, err
+		}
+	}
+	keyer, err := newCacheKeyer(ctx, config, graph)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := make([]*builtArchive, 0)
+	tasks := make([]*buildTask, 0)
+	for _, importPkg := range order {
+		if importPkg == "unsafe" || importPkg == pkg.ImportPath {
+			continue
+		}
+		conf := *config
+		conf.PkgPath = importPkg
+		conf.BuildPaths = []string{importPkg}
+		if err = initConfig(ctx, &conf, false); err != nil {
+			return nil, err
+		}
+		if err = conf.setTarget(importPkg); err != nil {
+			return nil, err
+		}
+		dep := &builtArchive{config: &conf, pkg: graph[importPkg], pkgPath: importPkg, path: conf.TargetPath}
+		key, err := keyer.key(dep.pkg)
+		if err != nil {
+			return nil, fmt.Errorf("could not compute cache key of %s: %w", importPkg, err)
+		}
+		tasks = append(tasks, &buildTask{
+			importPath: importPkg,
+			deps:       dep.pkg.Deps,
+			build: func(ctx context.Context) error {
+				var err error
+				dep.cached, err = cachedBuild(ctx, dep.config, key)
+				return err
+			},
+		})
+		deps = append(deps, dep)
+	}
+	if config.SkipHostDependencies {
+		deps, caller.skipped, err = buildHostDependencies(ctx, config, roots, deps, tasks)
+		return deps, err
+	}
+	if err = runBuildTasks(ctx, tasks, config.concurrency(), config.FailFast); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+func ListDependencies(config *BuildConfig) (*Package, []*Package, error) {
+	return ListDependenciesContext(context.Background(), config)
+}
+
+// ListDependenciesContext lists the root package of BuildPaths and the packages BuildWithDependencies
+// would build for it, in dependency order, without building anything.
+func ListDependenciesContext(ctx context.Context, config *BuildConfig) (*Package, []*Package, error) {
+	if err := initConfig(ctx, config, true); err != nil {
+		return nil, nil, err
+	}
+	workDir := config.WorkDir
+	if strings.HasSuffix(config.BuildPaths[0], ".go") {
+		workDir = filepath.Dir(config.BuildPaths[0])
+	}
+	pkg, _, err := getPkg(ctx, config, workDir, config.BuildPaths...)
+	if err != nil {
+		return nil, nil, err
+	}
+	graph, order, err := listDependencyGraph(ctx, config, pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+	deps := make([]*Package, 0, len(order))
+	for _, importPkg := range order {
+		if importPkg != "unsafe" && importPkg != pkg.ImportPath {
+			deps = append(deps, graph[importPkg])
+		}
+	}
+	return pkg, deps, nil
+}
+
+// resolvedImports returns the sorted package paths imported by pkg as the compiler resolved them,
+// vendored packages and packages renamed by ImportMap are reported by their actual package path.
+func resolvedImports(pkg *Package) []string {
+	seen := map[string]bool{}
+	imports := make([]string, 0, len(pkg.Imports))
+	for _, importPath := range pkg.Imports {
+		if mapped, ok := pkg.ImportMap[importPath]; ok {
+			importPath = mapped
+		}
+		if !seen[importPath] {
+			seen[importPath] = true
+			imports = append(imports, importPath)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+// listDependencyGraph resolves the whole import graph of pkg with a single go list invocation.
+// runtime is always part of the graph, and runtime/cgo whenever a package imports "C".
+func listDependencyGraph(ctx context.Context, config *BuildConfig, pkg *Package) (map[string]*Package, []string, error) {
+	patterns := pkg.Deps
+	if pkg.ImportPath != "command-line-arguments" {
+		patterns = []string{pkg.Dir}
+	}
+	patterns = append(append([]string{}, patterns...), "runtime")
+	for _, importPkg := range resolvedImports(pkg) {
+		if importPkg == "C" {
+			patterns = append(patterns, "runtime/cgo")
+		}
+	}
+
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	flags, err := config.listFlags()
+	if err != nil {
+		return nil, nil, err
+	}
+	graph, order, err := goListDeps(cmdCtx, config.toolchain(), config.WorkDir, config.environ(), flags, patterns...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, importPath := range order {
+		if err = packageErrors(graph[importPath]); err != nil {
+			if config.Offline {
+				return nil, nil, offlineError(graph[importPath])
+			}
+			return nil, nil, err
+		}
+	}
+	return graph, order, nil
+}
@@ -0,0 +1,101 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestBuildWithDependenciesReplay records a real BuildWithDependencies and replays it on the
+// FakeToolchain without any go binary, the replay must produce the same archives.
+func TestBuildWithDependenciesReplay(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go binary is not available")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.20\n",
+		"p/p.go": "package p\n\nimport \"strings\"\n\nfunc Upper(s string) string { return strings.ToUpper(s) }\n",
+	})
+	targetDir := filepath.Join(t.TempDir(), "target")
+	build := func(goBinary string, toolchain Toolchain) *BuildResult {
+		t.Helper()
+		config := &BuildConfig{
+			GoBinary:    goBinary,
+			BuildPaths:  []string{filepath.Join(dir, "p")},
+			PkgPath:     "example.com/m/p",
+			WorkDir:     dir,
+			KeepWorkDir: true,
+			TargetDir:   targetDir,
+			Toolchain:   toolchain,
+		}
+		result, err := BuildWithDependencies(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	fake := NewFakeToolchain()
+	recorded := build("", &RecordingToolchain{Toolchain: &ExecToolchain{}, Fake: fake})
+	archives := map[string][]byte{}
+	for _, path := range append([]string{recorded.TargetPath}, recorded.DepFiles...) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives[path] = data
+	}
+	recording := filepath.Join(t.TempDir(), "recording.json")
+	if err := fake.Save(recording); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(targetDir); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := LoadFakeToolchain(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := build(filepath.Join(dir, "no-go-binary"), replay)
+	if len(replay.Calls) == 0 {
+		t.Fatal("the fake toolchain received no call")
+	}
+	if replayed.TargetPath != recorded.TargetPath {
+		t.Errorf("TargetPath = %s, want %s", replayed.TargetPath, recorded.TargetPath)
+	}
+	if len(replayed.DepPkgPaths) != len(recorded.DepPkgPaths) {
+		t.Fatalf("DepPkgPaths = %v, want %v", replayed.DepPkgPaths, recorded.DepPkgPaths)
+	}
+	for i := range recorded.DepPkgPaths {
+		if replayed.DepPkgPaths[i] != recorded.DepPkgPaths[i] || replayed.DepFiles[i] != recorded.DepFiles[i] {
+			t.Errorf("dependency %d = %s at %s, want %s at %s", i, replayed.DepPkgPaths[i], replayed.DepFiles[i],
+				recorded.DepPkgPaths[i], recorded.DepFiles[i])
+		}
+	}
+	for path, want := range archives {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("replayed archive %s differs from the recorded one", path)
+		}
+	}
+}
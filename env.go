@@ -0,0 +1,91 @@
+package goloaderbuilder
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type EnvPolicy int
+
+const (
+	EnvInherit  EnvPolicy = iota // the environment of the process with BuildEnv and the config settings on top
+	EnvHermetic                  // only the EnvAllow variables of the process with BuildEnv and the config settings on top
+)
+
+// DefaultEnvAllow are the variables the go command needs to run, kept by EnvHermetic when EnvAllow is not set.
+var DefaultEnvAllow = []string{
+	"PATH", "HOME", "USER", "TMPDIR",
+	"USERPROFILE", "SYSTEMROOT", "TEMP", "TMP", "LOCALAPPDATA", "APPDATA", "PATHEXT", "COMSPEC",
+}
+
+func envKey(kv string) string {
+	if eq := strings.IndexByte(kv, '='); eq > 0 {
+		return kv[:eq]
+	}
+	return kv
+}
+
+func envKeyEqual(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// baseEnv returns the variables of the process passed to go commands according to EnvPolicy.
+func (config *BuildConfig) baseEnv() []string {
+	if config.EnvPolicy != EnvHermetic {
+		return os.Environ()
+	}
+	allow := config.EnvAllow
+	if allow == nil {
+		allow = DefaultEnvAllow
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		for _, key := range allow {
+			if envKeyEqual(envKey(kv), key) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return env
+}
+
+// cacheEnv returns the go build and module cache locations set by the config.
+func (config *BuildConfig) cacheEnv() []string {
+	var env []string
+	for _, dir := range []struct{ key, path string }{{"GOCACHE", config.GoCache}, {"GOMODCACHE", config.GoModCache}} {
+		if dir.path == "" {
+			continue
+		}
+		path, err := filepath.Abs(dir.path)
+		if err != nil {
+			path = dir.path
+		}
+		env = append(env, dir.key+"="+path)
+	}
+	return env
+}
+
+// overrideEnv returns the environment variables explicitly set by the config, they change
+// the archives and are part of their cache keys.
+func (config *BuildConfig) overrideEnv() []string {
+	var env []string
+	env = append(env, config.BuildEnv...)
+	env = append(env, config.hostEnv()...)
+	env = append(env, config.platformEnv()...)
+	env = append(env, config.offlineEnv()...)
+	return append(env, config.workEnv()...)
+}
+
+// environ returns the environment of every go command run for the config, later entries
+// take precedence over earlier ones with the same key.
+func (config *BuildConfig) environ() []string {
+	env := config.baseEnv()
+	env = append(env, config.overrideEnv()...)
+	return append(env, config.cacheEnv()...)
+}
@@ -0,0 +1,86 @@
+package goloaderbuilder
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type BuildError struct {
+	Args        []string     // command line of the failed go command
+	ExitCode    int          // exit code of the go command, -1 if it did not exit normally
+	Stdout      string       // stdout of the go command
+	Stderr      string       // stderr of the go command
+	Diagnostics []Diagnostic // compiler diagnostics parsed from stderr
+	Err         error        // underlying error returned by os/exec
+}
+
+type Diagnostic struct {
+	Package string // package being built when the diagnostic was reported, if known
+	File    string // source file path as reported by the compiler
+	Line    int    // line number, starting at 1
+	Column  int    // column number, 0 if not reported
+	Message string // diagnostic message, continuation lines joined by newlines
+}
+
+func (e *BuildError) Error() string {
+	msg := fmt.Sprintf("could not build with cmd '%s' (exit code %d)", strings.Join(e.Args, " "), e.ExitCode)
+	if len(e.Diagnostics) > 0 {
+		return msg + ": " + e.Diagnostics[0].String()
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	if e.Stderr != "" {
+		msg = fmt.Sprintf("%s\nstderr:\n%s", msg, e.Stderr)
+	}
+	return msg
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+func (d Diagnostic) String() string {
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+var diagnosticRegexp = regexp.MustCompile(`^(.+?\.(?:go|s|c|h|cc|cpp|cxx|m)):(\d+)(?::(\d+))?: (.*)$`)
+
+func parseDiagnostics(stderr, dir string) []Diagnostic {
+	var diagnostics []Diagnostic
+	pkg := ""
+	scanner := bufio.NewScanner(strings.NewReader(stderr))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# ") {
+			pkg = strings.TrimPrefix(line, "# ")
+			continue
+		}
+		if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			last := &diagnostics[len(diagnostics)-1]
+			last.Message += "\n" + strings.TrimPrefix(line, "\t")
+			continue
+		}
+		match := diagnosticRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		d := Diagnostic{Package: pkg, File: match[1], Message: match[4]}
+		if dir != "" && !filepath.IsAbs(d.File) {
+			d.File = filepath.Join(dir, d.File)
+		}
+		d.Line, _ = strconv.Atoi(match[2])
+		if match[3] != "" {
+			d.Column, _ = strconv.Atoi(match[3])
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
@@ -0,0 +1,43 @@
+package goloaderbuilder
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	dir := filepath.Join(string(filepath.Separator), "src")
+	tests := []struct {
+		name   string
+		stderr string
+		dir    string
+		want   []Diagnostic
+	}{
+		{name: "none", stderr: "go: downloading example.com/p v1.0.0\n"},
+		{
+			name:   "package",
+			stderr: "# example.com/p\n./p.go:3:2: undefined: x\n\thave int\n\twant string\np.s:5: unexpected EOF\nnote: module requires go 1.22\n",
+			dir:    dir,
+			want: []Diagnostic{
+				{Package: "example.com/p", File: filepath.Join(dir, "p.go"), Line: 3, Column: 2, Message: "undefined: x\nhave int\nwant string"},
+				{Package: "example.com/p", File: filepath.Join(dir, "p.s"), Line: 5, Message: "unexpected EOF"},
+			},
+		},
+		{
+			name:   "packages",
+			stderr: "# example.com/a\na.go:1:1: expected 'package'\n# example.com/b\n/abs/b.go:2:3: declared and not used: v\n",
+			want: []Diagnostic{
+				{Package: "example.com/a", File: "a.go", Line: 1, Column: 1, Message: "expected 'package'"},
+				{Package: "example.com/b", File: "/abs/b.go", Line: 2, Column: 3, Message: "declared and not used: v"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDiagnostics(tt.stderr, tt.dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiagnostics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,11 @@
 package main
 
 import (
-	"cmd/objfile/sys"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"runtime"
-	"strings"
-	"sync"
 
 	"github.com/pkujhd/goloader"
 	"github.com/pkujhd/goloaderbuilder"
@@ -39,9 +35,25 @@ func main() {
 	var keepWorkDir = flag.Bool("k", false, "keep work dir enable")
 	var workDir = flag.String("w", "./tmp", "build work dir")
 	var targetDir = flag.String("t", "./target", "build target dir")
-	var pkgPath = flag.String("p", "main", "package path")
+	var pkgPath = flag.String("p", "", "package path, derived from the import path or the directory if empty")
 	var goBinaryPath = flag.String("g", "go", "go binary path")
 	var onlyBuild = flag.Bool("b", false, "only build objfile")
+	var goos = flag.String("os", "", "target GOOS")
+	var goarch = flag.String("arch", "", "target GOARCH")
+	var microarch = flag.String("microarch", "", "target microarchitecture, such as GOAMD64 or GOARM64 value")
+	var offline = flag.Bool("offline", false, "never access the network, use the module cache or mirror dir")
+	var mirrorDir = flag.String("mirror", "", "module mirror dir used in offline mode")
+	var modMode = flag.String("mod", "", "module download mode, mod, readonly or vendor")
+	var goWork = flag.String("gowork", "", "go.work file of a multi-module workspace, off disables workspace mode")
+	var hermetic = flag.Bool("hermetic", false, "only pass PATH, HOME and temp dir variables of the environment to go commands")
+	var goCache = flag.String("gocache", "", "GOCACHE of go commands")
+	var goModCache = flag.String("gomodcache", "", "GOMODCACHE of go commands")
+	var cgoBundle = flag.Bool("cgobundle", false, "extract the host objects of cgo packages instead of failing")
+	var exportDir = flag.String("export", "", "export modules required by work dir into a module mirror dir and exit")
+	var test = flag.Bool("test", false, "build the tests of the package, run them with the runner -test flag")
+	var pinHost = flag.Bool("pin", false, "build with the module versions of the exe file, in a copy of go.mod")
+	var inheritHost = flag.Bool("inherit", false, "build with the tags, GOEXPERIMENT, race and trimpath settings of the exe file")
+	var skipHost = flag.Bool("skiphost", false, "only build the dependencies providing symbols missing from the exe file")
 
 	flag.Parse()
 
@@ -52,35 +64,69 @@ func main() {
 	config.DebugLog = *debug
 	config.WorkDir = *workDir
 	config.BuildPaths = files.Data
-	config.Dynlink = *dynlink
+	if !*dynlink {
+		config.Dynlink = goloaderbuilder.DynlinkOff
+	}
 	config.PkgPath = *pkgPath
 	config.TargetDir = *targetDir
+	config.GOOS = *goos
+	config.GOARCH = *goarch
+	config.Microarch = *microarch
+	config.Offline = *offline
+	config.MirrorDir = *mirrorDir
+	config.ModMode = goloaderbuilder.ModMode(*modMode)
+	config.GoWork = *goWork
+	if *hermetic {
+		config.EnvPolicy = goloaderbuilder.EnvHermetic
+	}
+	config.GoCache = *goCache
+	config.GoModCache = *goModCache
+	config.Host = *exeFile
+	config.PinHostModules = *pinHost
+	config.InheritHostSettings = *inheritHost
+	config.SkipHostDependencies = *skipHost
+	if *cgoBundle {
+		config.CgoMode = goloaderbuilder.CgoBundle
+	}
 
-	if runtime.GOARCH == sys.ArchAMD64.Name && runtime.GOOS == "linux" {
-		config.Dynlink = false
+	if *exportDir != "" {
+		if _, err := goloaderbuilder.ExportModules(&config, *exportDir); err != nil {
+			fmt.Printf("export failed! error:%s\n", err)
+		}
+		return
 	}
 
-	err := build(&config, *exeFile, *onlyBuild)
+	err := build(&config, *exeFile, *onlyBuild, *test)
 	if err != nil {
 		fmt.Printf("build failed! error:%s\n", err)
 	}
 }
 
-func build(config *goloaderbuilder.BuildConfig, exeFile string, onlyBuild bool) error {
+func build(config *goloaderbuilder.BuildConfig, exeFile string, onlyBuild bool, test bool) error {
 	if len(config.BuildPaths) == 0 {
 		return fmt.Errorf("empty buildPath!\n")
 	}
-	var pkg *goloaderbuilder.Package
+	var result *goloaderbuilder.BuildResult
 	var err error
-	if strings.HasSuffix(config.BuildPaths[0], ".go") {
-		pkg, err = goloaderbuilder.BuildGoFiles(config)
+	if test {
+		result, err = goloaderbuilder.BuildTestPackage(config)
 	} else {
-		pkg, err = goloaderbuilder.BuildGoPackage(config)
+		result, err = goloaderbuilder.BuildWithDependencies(config)
 	}
-
 	if err != nil {
 		return err
 	}
+	if report := result.HostModules; report != nil {
+		for _, mismatch := range report.Mismatches {
+			fmt.Printf("module %s: %s, exe file has %s\n", mismatch.Path, mismatch.Version, mismatch.HostVersion)
+		}
+		for _, pinned := range report.Pinned {
+			fmt.Printf("pinned module %s@%s in %s\n", pinned.Path, pinned.Version, report.ModFile)
+		}
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("skipped %s: %s\n", skipped.ImportPath, skipped.Reason)
+	}
 	if onlyBuild {
 		return nil
 	}
@@ -96,14 +142,7 @@ func build(config *goloaderbuilder.BuildConfig, exeFile string, onlyBuild bool)
 	unresolvedSymbols := goloader.UnresolvedSymbols(linker, symPtr)
 
 	if len(unresolvedSymbols) > 0 {
-		files := make([]string, 0)
-		pkgPaths := make([]string, 0)
-		pkg.Imports = append(pkg.Imports, "runtime")
-		if err = buildDepPackage(&files, &pkgPaths, pkg.Imports, config); err != nil {
-			return err
-		}
-
-		err = goloader.ReadDependPackages(linker, files, pkgPaths, unresolvedSymbols, symPtr)
+		err = goloader.ReadDependPackages(linker, result.DepFiles, result.DepPkgPaths, unresolvedSymbols, symPtr)
 		if err != nil {
 			return err
 		}
@@ -117,12 +156,21 @@ func build(config *goloaderbuilder.BuildConfig, exeFile string, onlyBuild bool)
 	if err = serializeLinker(config, linker); err != nil {
 		return err
 	}
+	if err = goloaderbuilder.WriteLinkMetadata(config, result, exeFile, filepath.Join(config.PlatformDir(), config.PkgPath)+".goloader"); err != nil {
+		return err
+	}
+	for _, objects := range result.Cgo {
+		fmt.Printf("cgo package %s: host objects %v, libraries %v\n", objects.ImportPath, objects.Objects, objects.Libraries)
+	}
+	if result.Entry != "" {
+		fmt.Printf("run entry %s\n", result.Entry)
+	}
 
 	return nil
 }
 
 func serializeLinker(config *goloaderbuilder.BuildConfig, linker *goloader.Linker) error {
-	serializeFilePath := filepath.Join(config.TargetDir, config.PkgPath) + ".goloader"
+	serializeFilePath := filepath.Join(config.PlatformDir(), config.PkgPath) + ".goloader"
 	f, err := os.Create(serializeFilePath)
 	if err != nil {
 		return err
@@ -135,44 +183,3 @@ func serializeLinker(config *goloaderbuilder.BuildConfig, linker *goloader.Linke
 	defer f.Close()
 	return nil
 }
-
-func buildDepPackage(files, pkgPaths *[]string, imports []string, config *goloaderbuilder.BuildConfig) error {
-	if len(imports) == 0 {
-		return nil
-	}
-	importPkgs := make(map[string]bool)
-	importPkgs["unsafe"] = true
-	addImport := func(importPkgs map[string]bool, imports []string) {
-		for _, importPkg := range imports {
-			if importPkg == "C" {
-				importPkg = "runtime/cgo"
-			}
-			if _, ok := importPkgs[importPkg]; !ok {
-				importPkgs[importPkg] = false
-			}
-		}
-	}
-	addImport(importPkgs, imports)
-
-	wg := &sync.WaitGroup{}
-
-LOOP:
-	for importPkg, dealed := range importPkgs {
-		if dealed == false {
-			conf := *config
-			conf.PkgPath = importPkg
-			conf.BuildPaths = []string{importPkg}
-			pkg, err := goloaderbuilder.BuildDepPackage(&conf, wg)
-			if err != nil {
-				return err
-			}
-			*files = append(*files, conf.TargetPath)
-			*pkgPaths = append(*pkgPaths, importPkg)
-			importPkgs[importPkg] = true
-			addImport(importPkgs, pkg.Imports)
-			goto LOOP
-		}
-	}
-	wg.Wait()
-	return nil
-}
@@ -2,6 +2,11 @@ module github.com/pkujhd/goloaderbuilder/examples/runner
 
 go 1.11
 
-require github.com/pkujhd/goloader v0.0.0-20250930031008-a0b6f05e99be
+require (
+	github.com/pkujhd/goloader v0.0.0-20250930031008-a0b6f05e99be
+	github.com/pkujhd/goloaderbuilder v0.0.0-20250728085808-47f0b7578647
+)
+
+replace github.com/pkujhd/goloaderbuilder => ../../
 
 //replace github.com/pkujhd/goloader => ../../../goloader
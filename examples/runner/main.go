@@ -8,14 +8,24 @@ import (
 	"unsafe"
 
 	"github.com/pkujhd/goloader"
+	"github.com/pkujhd/goloaderbuilder"
 )
 
 func main() {
 	var goloaderFile = flag.String("f", "", "go loader builder file.")
 	var run = flag.String("r", "main.main", "run functionname")
+	var test = flag.String("test", "", "run the tests of this package built with the builder -test flag, the -r function is its test main and arguments are test flags")
+	var force = flag.Bool("force", false, "load the file even when it was linked for another host executable")
 
 	flag.Parse()
 
+	if !*force {
+		if err := checkHost(*goloaderFile); err != nil {
+			fmt.Printf("check host failed!error:%s\n", err)
+			return
+		}
+	}
+
 	f, err := os.Open(*goloaderFile)
 	if err != nil {
 		fmt.Printf("open file:%s failed!\n", *goloaderFile)
@@ -37,7 +47,11 @@ func main() {
 		return
 	}
 
-	err = runMain(linker, symPtr, *run)
+	if *test != "" {
+		err = runTest(linker, symPtr, *run, *test, flag.Args())
+	} else {
+		err = runMain(linker, symPtr, *run)
+	}
 	if err != nil {
 		fmt.Printf("run function failed!error:%s\n", err)
 		return
@@ -61,3 +75,36 @@ func runMain(linker *goloader.Linker, symPtr map[string]uintptr, run string) err
 	codeModule.Unload()
 	return nil
 }
+
+func runTest(linker *goloader.Linker, symPtr map[string]uintptr, run string, pkg string, args []string) error {
+	codeModule, err := goloader.Load(linker, symPtr)
+	if err != nil {
+		return err
+	}
+
+	runFuncPtr := codeModule.Syms[run]
+	if runFuncPtr == 0 {
+		return fmt.Errorf("test main %s not found", run)
+	}
+	funcPtrContainer := (uintptr)(unsafe.Pointer(&runFuncPtr))
+	testMain := *(*func([]string) int)(unsafe.Pointer(&funcPtrContainer))
+	exitCode, err := goloaderbuilder.RunTestJSON(os.Stdout, pkg, args, testMain)
+	codeModule.Unload()
+	if err != nil {
+		return err
+	}
+	os.Exit(exitCode)
+	return nil
+}
+
+func checkHost(goloaderFile string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	verified, err := goloaderbuilder.CheckHost(goloaderFile, exe)
+	if err == nil && !verified {
+		fmt.Printf("warning: %s has no recorded host fingerprint, its host executable is unknown\n", goloaderFile)
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FakeToolchain replays recorded go command output instead of running the go binary,
+// it is meant for hermetic tests of code built on goloaderbuilder.
+type FakeToolchain struct {
+	Recordings []*FakeRecording  // recorded go commands, matched on the subcommand and the whole invocation
+	EnvValues  map[string]string // values reported by go env when no recording matches
+	Calls      []FakeCall        `json:"-"` // every invocation received, in order
+
+	mu sync.Mutex
+}
+
+type FakeCall struct {
+	Subcommand string     // go subcommand, such as "list" or "build"
+	Invocation Invocation // invocation passed to the toolchain
+}
+
+// FakeRecording is the outcome of one go command.
+type FakeRecording struct {
+	Subcommand string     // go subcommand, such as "list" or "build"
+	Invocation Invocation // dir, environment and arguments of the command
+	Stdout     []byte     // stdout of the command
+	Archive    []byte     `json:",omitempty"` // content written to the -o target of go build
+	ExitCode   int        `json:",omitempty"` // exit code of a failed command, 0 on success
+	Stderr     string     `json:",omitempty"` // stderr of a failed command
+}
+
+func NewFakeToolchain() *FakeToolchain {
+	return &FakeToolchain{
+		EnvValues: map[string]string{},
+	}
+}
+
+func LoadFakeToolchain(path string) (*FakeToolchain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := NewFakeToolchain()
+	if err = json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("failed to decode fake toolchain recording %s: %w", path, err)
+	}
+	return t, nil
+}
+
+func (t *FakeToolchain) Save(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, err := json.MarshalIndent(t, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (inv *Invocation) equal(other *Invocation) bool {
+	return inv.Dir == other.Dir && equalStrings(inv.Env, other.Env) && equalStrings(inv.Args, other.Args)
+}
+
+func (inv *Invocation) clone() Invocation {
+	return Invocation{
+		Dir:  inv.Dir,
+		Env:  append([]string(nil), inv.Env...),
+		Args: append([]string(nil), inv.Args...),
+	}
+}
+
+// add stores recording, replacing an earlier recording of the same command.
+func (t *FakeToolchain) add(recording *FakeRecording) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, r := range t.Recordings {
+		if r.Subcommand == recording.Subcommand && r.Invocation.equal(&recording.Invocation) {
+			t.Recordings[i] = recording
+			return
+		}
+	}
+	t.Recordings = append(t.Recordings, recording)
+}
+
+func (t *FakeToolchain) lookup(subcommand string, inv *Invocation) *FakeRecording {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Calls = append(t.Calls, FakeCall{Subcommand: subcommand, Invocation: inv.clone()})
+	for _, r := range t.Recordings {
+		if r.Subcommand == subcommand && r.Invocation.equal(inv) {
+			return r
+		}
+	}
+	return nil
+}
+
+func outputArg(args []string) string {
+	for i, arg := range args {
+		if arg == "-o" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "-o=") {
+			return strings.TrimPrefix(arg, "-o=")
+		}
+	}
+	return ""
+}
+
+func outputPath(inv *Invocation) string {
+	target := outputArg(inv.Args)
+	if target != "" && !filepath.IsAbs(target) && inv.Dir != "" {
+		target = filepath.Join(inv.Dir, target)
+	}
+	return target
+}
+
+func (t *FakeToolchain) replay(subcommand string, inv *Invocation) ([]byte, error) {
+	return t.replayRecording(subcommand, inv, t.lookup(subcommand, inv))
+}
+
+func (t *FakeToolchain) replayRecording(subcommand string, inv *Invocation, r *FakeRecording) ([]byte, error) {
+	if r == nil {
+		return nil, &BuildError{
+			Args:     append([]string{"go", subcommand}, inv.Args...),
+			ExitCode: 1,
+			Stderr:   fmt.Sprintf("fake toolchain has no recording of go %s %s in %s", subcommand, strings.Join(inv.Args, " "), inv.Dir),
+		}
+	}
+	if r.ExitCode != 0 {
+		return r.Stdout, &BuildError{
+			Args:        append([]string{"go", subcommand}, inv.Args...),
+			ExitCode:    r.ExitCode,
+			Stdout:      string(r.Stdout),
+			Stderr:      r.Stderr,
+			Diagnostics: parseDiagnostics(r.Stderr, inv.Dir),
+		}
+	}
+	if target := outputPath(inv); r.Archive != nil && target != "" {
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(target, r.Archive, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return r.Stdout, nil
+}
+
+func (t *FakeToolchain) List(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.replay("list", inv)
+}
+
+func (t *FakeToolchain) Build(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.replay("build", inv)
+}
+
+func (t *FakeToolchain) Mod(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.replay("mod", inv)
+}
+
+func (t *FakeToolchain) Get(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.replay("get", inv)
+}
+
+func (t *FakeToolchain) Tool(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.replay("tool", inv)
+}
+
+// Env replays a recorded go env, or reports EnvValues when no recording matches.
+func (t *FakeToolchain) Env(ctx context.Context, inv *Invocation) ([]byte, error) {
+	if r := t.lookup("env", inv); r != nil {
+		return t.replayRecording("env", inv, r)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	jsonOutput := false
+	var keys []string
+	for _, arg := range inv.Args {
+		if arg == "-json" {
+			jsonOutput = true
+		} else if !strings.HasPrefix(arg, "-") {
+			keys = append(keys, arg)
+		}
+	}
+	if len(keys) == 0 {
+		for key := range t.EnvValues {
+			keys = append(keys, key)
+		}
+	}
+	if jsonOutput {
+		values := map[string]string{}
+		for _, key := range keys {
+			values[key] = t.EnvValues[key]
+		}
+		return json.Marshal(values)
+	}
+	var output []byte
+	for _, key := range keys {
+		output = append(output, t.EnvValues[key]...)
+		output = append(output, '\n')
+	}
+	return output, nil
+}
+
+// RecordingToolchain runs every command with Toolchain and stores its outcome into Fake,
+// so that a real build can be replayed later by the FakeToolchain.
+type RecordingToolchain struct {
+	Toolchain
+	Fake *FakeToolchain
+}
+
+func (t *RecordingToolchain) record(subcommand string, inv *Invocation, output []byte, err error) ([]byte, error) {
+	recording := &FakeRecording{Subcommand: subcommand, Invocation: inv.clone(), Stdout: output}
+	var buildErr *BuildError
+	if errors.As(err, &buildErr) {
+		if errors.Is(buildErr.Err, context.Canceled) || errors.Is(buildErr.Err, context.DeadlineExceeded) {
+			return output, err
+		}
+		recording.ExitCode = buildErr.ExitCode
+		recording.Stderr = buildErr.Stderr
+	} else if err != nil {
+		return output, err
+	}
+	if target := outputPath(inv); err == nil && subcommand == "build" && target != "" {
+		archive, readErr := os.ReadFile(target)
+		if readErr != nil {
+			return output, readErr
+		}
+		recording.Archive = archive
+	}
+	t.Fake.add(recording)
+	return output, err
+}
+
+func (t *RecordingToolchain) List(ctx context.Context, inv *Invocation) ([]byte, error) {
+	output, err := t.Toolchain.List(ctx, inv)
+	return t.record("list", inv, output, err)
+}
+
+func (t *RecordingToolchain) Build(ctx context.Context, inv *Invocation) ([]byte, error) {
+	output, err := t.Toolchain.Build(ctx, inv)
+	return t.record("build", inv, output, err)
+}
+
+func (t *RecordingToolchain) Mod(ctx context.Context, inv *Invocation) ([]byte, error) {
+	output, err := t.Toolchain.Mod(ctx, inv)
+	return t.record("mod", inv, output, err)
+}
+
+func (t *RecordingToolchain) Get(ctx context.Context, inv *Invocation) ([]byte, error) {
+	output, err := t.Toolchain.Get(ctx, inv)
+	return t.record("get", inv, output, err)
+}
+
+func (t *RecordingToolchain) Env(ctx context.Context, inv *Invocation) ([]byte, error) {
+	output, err := t.Toolchain.Env(ctx, inv)
+	return t.record("env", inv, output, err)
+}
+
+// Tool runs go tool with Toolchain when it implements ToolRunner, and with the go binary in PATH otherwise.
+func (t *RecordingToolchain) Tool(ctx context.Context, inv *Invocation) ([]byte, error) {
+	runner, ok := t.Toolchain.(ToolRunner)
+	if !ok {
+		runner = &ExecToolchain{}
+	}
+	output, err := runner.Tool(ctx, inv)
+	return t.record("tool", inv, output, err)
+}
@@ -0,0 +1,168 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"debug/elf"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HostFingerprint identifies the host executable a .goloader file was linked for.
+type HostFingerprint struct {
+	BuildID    string          // go build ID of the executable
+	GoVersion  string          // go version the executable was built with
+	GOOS       string          // operating system of the executable
+	GOARCH     string          // architecture of the executable
+	MainModule ModuleVersion   // main module of the executable
+	Modules    []ModuleVersion // dependency modules of the executable, replacements are reported as "path => new@version"
+}
+
+type HostMismatchError struct {
+	Path     string           // .goloader file
+	Expected *HostFingerprint // host the file was linked for
+	Actual   *HostFingerprint // host loading the file
+	Diff     []string         // differences, one per line
+}
+
+func (e *HostMismatchError) Error() string {
+	return fmt.Sprintf("%s was linked for another host executable:\n\t%s", e.Path, strings.Join(e.Diff, "\n\t"))
+}
+
+var goBuildIDPrefix = []byte("\xff Go build ID: \"")
+
+// readBuildID returns the go build ID of an executable, from its ELF note or else from the start
+// of its text segment, where the linker writes it for every object format.
+func readBuildID(path string) (string, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		if sect := f.Section(".note.go.buildid"); sect != nil {
+			data, err := sect.Data()
+			if err != nil {
+				return "", err
+			}
+			// note header: name size, description size, type, then the name "Go\x00\x00"
+			if len(data) >= 16 {
+				if size := int(f.ByteOrder.Uint32(data[4:])); 16+size <= len(data) {
+					return string(data[16 : 16+size]), nil
+				}
+			}
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	start := bytes.Index(data, goBuildIDPrefix)
+	if start < 0 {
+		return "", fmt.Errorf("%s has no go build ID", path)
+	}
+	quoted := data[start+len(goBuildIDPrefix)-1:]
+	end := bytes.Index(quoted, []byte("\"\n \xff"))
+	if end < 0 {
+		return "", fmt.Errorf("%s has a malformed go build ID", path)
+	}
+	return strconv.Unquote(string(quoted[:end+1]))
+}
+
+// ReadHostFingerprint reads the fingerprint of the executable at path from its build ID and build info.
+func ReadHostFingerprint(path string) (*HostFingerprint, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read build info of %s: %w", path, err)
+	}
+	fingerprint := &HostFingerprint{
+		GoVersion:  info.GoVersion,
+		MainModule: ModuleVersion{Path: info.Main.Path, Version: info.Main.Version},
+	}
+	if fingerprint.BuildID, err = readBuildID(path); err != nil {
+		return nil, err
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "GOOS":
+			fingerprint.GOOS = setting.Value
+		case "GOARCH":
+			fingerprint.GOARCH = setting.Value
+		}
+	}
+	for _, dep := range info.Deps {
+		module := ModuleVersion{Path: dep.Path, Version: dep.Version}
+		if dep.Replace != nil {
+			module.Path += " => " + dep.Replace.Path
+			module.Version = dep.Replace.Version
+		}
+		fingerprint.Modules = append(fingerprint.Modules, module)
+	}
+	sort.Slice(fingerprint.Modules, func(i, j int) bool { return fingerprint.Modules[i].Path < fingerprint.Modules[j].Path })
+	return fingerprint, nil
+}
+
+// Diff returns the differences between the fingerprint f expected by a .goloader file and the
+// fingerprint actual of the host loading it, an empty diff means the same executable. Fields
+// missing from f are unknown and not compared.
+func (f *HostFingerprint) Diff(actual *HostFingerprint) []string {
+	var diff []string
+	compare := func(name, expected, got string) {
+		if expected != "" && expected != got {
+			diff = append(diff, fmt.Sprintf("%s: linked for %q, host has %q", name, expected, got))
+		}
+	}
+	compare("go version", f.GoVersion, actual.GoVersion)
+	compare("GOOS", f.GOOS, actual.GOOS)
+	compare("GOARCH", f.GOARCH, actual.GOARCH)
+	if f.MainModule.Path != "" {
+		compare("main module", f.MainModule.Path+"@"+f.MainModule.Version, actual.MainModule.Path+"@"+actual.MainModule.Version)
+	}
+	if f.Modules == nil {
+		compare("build ID", f.BuildID, actual.BuildID)
+		return diff
+	}
+
+	modules := map[string]string{}
+	for _, module := range actual.Modules {
+		modules[module.Path] = module.Version
+	}
+	for _, module := range f.Modules {
+		version, ok := modules[module.Path]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("module %s@%s: missing in host", module.Path, module.Version))
+			continue
+		}
+		compare("module "+module.Path, module.Version, version)
+		delete(modules, module.Path)
+	}
+	var added []string
+	for path, version := range modules {
+		added = append(added, fmt.Sprintf("module %s@%s: only in host", path, version))
+	}
+	sort.Strings(added)
+	diff = append(diff, added...)
+	compare("build ID", f.BuildID, actual.BuildID)
+	return diff
+}
+
+// CheckHost verifies that the .goloader file at goloaderPath was linked for the host executable at
+// hostPath, usually os.Executable() of the process about to load it. It fails with a *HostMismatchError
+// describing the differences. When no fingerprint was recorded for the file its host is unknown,
+// CheckHost then reports false without error and the caller should warn before loading it.
+func CheckHost(goloaderPath, hostPath string) (bool, error) {
+	metadata, err := ReadLinkMetadata(goloaderPath)
+	if err != nil {
+		return false, err
+	}
+	if metadata == nil || metadata.HostFingerprint == nil {
+		return false, nil
+	}
+	actual, err := ReadHostFingerprint(hostPath)
+	if err != nil {
+		return false, err
+	}
+	if diff := metadata.HostFingerprint.Diff(actual); len(diff) > 0 {
+		return false, &HostMismatchError{Path: goloaderPath, Expected: metadata.HostFingerprint, Actual: actual, Diff: diff}
+	}
+	return true, nil
+}
@@ -0,0 +1,104 @@
+package goloaderbuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHostFingerprintDiff(t *testing.T) {
+	host := func(change func(f *HostFingerprint)) *HostFingerprint {
+		f := &HostFingerprint{
+			BuildID:    "a/b/c/d",
+			GoVersion:  "go1.22.0",
+			GOOS:       "linux",
+			GOARCH:     "amd64",
+			MainModule: ModuleVersion{Path: "example.com/host", Version: "(devel)"},
+			Modules: []ModuleVersion{
+				{Path: "example.com/lib", Version: "v1.0.0"},
+				{Path: "example.com/old => ../old", Version: ""},
+			},
+		}
+		if change != nil {
+			change(f)
+		}
+		return f
+	}
+	tests := []struct {
+		name   string
+		actual *HostFingerprint
+		want   []string
+	}{
+		{"same", host(nil), nil},
+		{"rebuilt", host(func(f *HostFingerprint) { f.BuildID = "a/b/c/e" }),
+			[]string{`build ID: linked for "a/b/c/d", host has "a/b/c/e"`}},
+		{"toolchain", host(func(f *HostFingerprint) { f.GoVersion, f.GOARCH = "go1.22.1", "arm64" }),
+			[]string{`go version: linked for "go1.22.0", host has "go1.22.1"`, `GOARCH: linked for "amd64", host has "arm64"`}},
+		{"main module", host(func(f *HostFingerprint) { f.MainModule.Version = "v1.0.0" }),
+			[]string{`main module: linked for "example.com/host@(devel)", host has "example.com/host@v1.0.0"`}},
+		{"modules", host(func(f *HostFingerprint) {
+			f.Modules = []ModuleVersion{{Path: "example.com/lib", Version: "v1.1.0"}, {Path: "example.com/z", Version: "v0.1.0"}, {Path: "example.com/new", Version: "v2.0.0"}}
+		}), []string{
+			`module example.com/lib: linked for "v1.0.0", host has "v1.1.0"`,
+			"module example.com/old => ../old@: missing in host",
+			"module example.com/new@v2.0.0: only in host",
+			"module example.com/z@v0.1.0: only in host",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := host(nil).Diff(tt.actual); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostFingerprintDiffUnknown(t *testing.T) {
+	actual := &HostFingerprint{BuildID: "a/b/c/d", GoVersion: "go1.22.0", GOOS: "linux", GOARCH: "amd64",
+		MainModule: ModuleVersion{Path: "example.com/host"}, Modules: []ModuleVersion{{Path: "example.com/lib", Version: "v1.0.0"}}}
+	if diff := (&HostFingerprint{GoVersion: "go1.22.0"}).Diff(actual); len(diff) != 0 {
+		t.Errorf("Diff() of a partial fingerprint = %q, want no difference", diff)
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := ReadHostFingerprint(exe)
+	if err != nil {
+		t.Skip(err)
+	}
+	writeMetadata := func(t *testing.T, fingerprint *HostFingerprint) string {
+		path := filepath.Join(t.TempDir(), "p.goloader")
+		data, err := json.Marshal(&LinkMetadata{HostFingerprint: fingerprint})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(linkMetadataPath(path), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if verified, err := CheckHost(filepath.Join(t.TempDir(), "p.goloader"), exe); verified || err != nil {
+		t.Errorf("without metadata: CheckHost = %v, %v, want an unknown host without error", verified, err)
+	}
+	if verified, err := CheckHost(writeMetadata(t, nil), exe); verified || err != nil {
+		t.Errorf("without fingerprint: CheckHost = %v, %v, want an unknown host without error", verified, err)
+	}
+	if verified, err := CheckHost(writeMetadata(t, fingerprint), exe); !verified || err != nil {
+		t.Errorf("same host: CheckHost = %v, %v, want a verified host", verified, err)
+	}
+	other := *fingerprint
+	other.BuildID += "x"
+	var mismatch *HostMismatchError
+	if verified, err := CheckHost(writeMetadata(t, &other), exe); verified || !errors.As(err, &mismatch) {
+		t.Errorf("other host: CheckHost = %v, %v, want a *HostMismatchError", verified, err)
+	}
+}
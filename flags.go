@@ -0,0 +1,322 @@
+package goloaderbuilder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// perPackageBuildFlags take a list of arguments for the packages matching an optional
+// pattern, as in -gcflags=all=-N.
+var perPackageBuildFlags = map[string]bool{"gcflags": true, "asmflags": true, "ldflags": true, "gccgoflags": true}
+
+var boolBuildFlags = map[string]bool{
+	"a": true, "n": true, "v": true, "x": true, "work": true, "race": true, "msan": true, "asan": true,
+	"cover": true, "trimpath": true, "modcacherw": true, "buildvcs": true, "json": true,
+}
+
+var valueBuildFlags = map[string]bool{
+	"p": true, "compiler": true, "covermode": true, "coverpkg": true, "installsuffix": true, "mod": true,
+	"modfile": true, "overlay": true, "pkgdir": true, "toolexec": true, "pgo": true, "tags": true,
+}
+
+// rejectedBuildFlags change what go build produces and can not be combined with the builder.
+var rejectedBuildFlags = map[string]string{
+	"o":          "the archive is always written to TargetPath",
+	"buildmode":  "archives are always built in the default build mode",
+	"linkshared": "archives are linked by goloader, not against shared libraries",
+	"C":          "set WorkDir instead",
+}
+
+// listBuildFlags change which files and packages go list reports.
+var listBuildFlags = map[string]bool{
+	"tags": true, "race": true, "msan": true, "asan": true, "mod": true, "modfile": true, "overlay": true,
+	"compiler": true, "pgo": true,
+}
+
+type patternArgs struct {
+	pattern string
+	args    []string
+}
+
+// splitQuoted splits s into space separated fields, a field may be quoted with ' or " to contain spaces.
+func splitQuoted(s string) ([]string, error) {
+	var fields []string
+	for s = strings.TrimLeft(s, " \t\n\r"); s != ""; s = strings.TrimLeft(s, " \t\n\r") {
+		if s[0] == '"' || s[0] == '\'' {
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %c string", s[0])
+			}
+			fields = append(fields, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t\n\r")
+		if end < 0 {
+			end = len(s)
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+	return fields, nil
+}
+
+func joinQuoted(fields []string) string {
+	quoted := make([]string, 0, len(fields))
+	for _, field := range fields {
+		switch {
+		case !strings.ContainsAny(field, " \t\n\r'\"") && field != "":
+			quoted = append(quoted, field)
+		case !strings.Contains(field, "'"):
+			quoted = append(quoted, "'"+field+"'")
+		default:
+			quoted = append(quoted, `"`+field+`"`)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// flagName returns the name of the build flag arg, such as "gcflags" for "-gcflags=all=-N".
+func flagName(arg string) string {
+	name := strings.TrimLeft(strings.TrimSpace(arg), "-")
+	if eq := strings.IndexByte(name, '='); eq >= 0 {
+		name = name[:eq]
+	}
+	return name
+}
+
+// mergeBuildFlags parses extraBuildFlags the way go build does and merges repeated flags instead
+// of letting the last one win. Arguments of -gcflags, -asmflags, -ldflags and -gccgoflags are
+// concatenated per package pattern, -tags are united, and other flags given twice must agree.
+// When dynlink is set, -dynlink is added to the compiler flags of every pattern and of an
+// unpatterned group placed before them. The -tags, -race and -trimpath settings of host are
+// applied when it is not nil, extra flags must agree with them.
+// Flags unknown to the builder are passed through unchanged.
+func mergeBuildFlags(extraBuildFlags []string, dynlink bool, host *HostSettings) ([]string, error) {
+	var order []string
+	perPackage := map[string][]*patternArgs{}
+	values := map[string]string{}
+	var tags []string
+	var unknown []string
+	seen := func(name string) bool {
+		for _, flagName := range order {
+			if flagName == name {
+				return true
+			}
+		}
+		order = append(order, name)
+		return false
+	}
+
+	for i := 0; i < len(extraBuildFlags); i++ {
+		arg := strings.TrimSpace(extraBuildFlags[i])
+		if arg == "" {
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("unexpected build argument %q, packages are set with BuildPaths", arg)
+		}
+		name := flagName(arg)
+		value, hasValue := "", strings.Contains(arg, "=")
+		if hasValue {
+			value = arg[strings.IndexByte(arg, '=')+1:]
+		}
+		if reason, ok := rejectedBuildFlags[name]; ok {
+			return nil, fmt.Errorf("build flag -%s is not supported, %s", name, reason)
+		}
+		if !boolBuildFlags[name] && !valueBuildFlags[name] && !perPackageBuildFlags[name] {
+			unknown = append(unknown, arg)
+			continue
+		}
+		if boolBuildFlags[name] {
+			if !hasValue {
+				value = "true"
+			}
+		} else if !hasValue {
+			if i+1 >= len(extraBuildFlags) {
+				return nil, fmt.Errorf("build flag -%s needs an argument", name)
+			}
+			i++
+			value = extraBuildFlags[i]
+		}
+
+		switch {
+		case perPackageBuildFlags[name]:
+			seen(name)
+			pattern, args, err := parsePatternArgs(name, value)
+			if err != nil {
+				return nil, err
+			}
+			perPackage[name] = appendPatternArgs(perPackage[name], pattern, args)
+		case name == "tags":
+			seen(name)
+			sep := ","
+			if !strings.Contains(value, ",") {
+				sep = " "
+			}
+			for _, tag := range strings.Split(value, sep) {
+				if tag = strings.TrimSpace(tag); tag != "" && !containsString(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		default:
+			if seen(name) && values[name] != value {
+				return nil, fmt.Errorf("conflicting build flags -%s=%s and -%s=%s", name, values[name], name, value)
+			}
+			values[name] = value
+		}
+	}
+
+	if host != nil {
+		for _, tag := range tags {
+			if !containsString(host.Tags, tag) {
+				return nil, fmt.Errorf("build tag %s is not set by the host executable %s, built with -tags=%s", tag, host.Path, strings.Join(host.Tags, ","))
+			}
+		}
+		if len(host.Tags) > 0 {
+			seen("tags")
+			tags = append([]string{}, host.Tags...)
+		}
+		for _, setting := range []struct {
+			name  string
+			value bool
+		}{{"race", host.Race}, {"trimpath", host.Trimpath}} {
+			if !containsString(order, setting.name) {
+				if setting.value {
+					seen(setting.name)
+					values[setting.name] = "true"
+				}
+				continue
+			}
+			if value, err := strconv.ParseBool(values[setting.name]); err != nil || value != setting.value {
+				return nil, fmt.Errorf("build flag -%s=%s conflicts with the host executable %s, built with -%s=%t", setting.name, values[setting.name], host.Path, setting.name, setting.value)
+			}
+		}
+	}
+
+	if dynlink {
+		// the last group matching a package replaces the others, so -dynlink is added to every
+		// group, and an unpatterned group comes first for the packages no pattern matches
+		seen("gcflags")
+		groups := perPackage["gcflags"]
+		if len(groups) == 0 || groups[0].pattern != "" {
+			groups = append([]*patternArgs{{}}, groups...)
+		}
+		for _, group := range groups {
+			if !containsString(group.args, "-dynlink") {
+				group.args = append([]string{"-dynlink"}, group.args...)
+			}
+		}
+		perPackage["gcflags"] = groups
+	}
+
+	buildFlags := []string{}
+	for _, name := range order {
+		switch {
+		case perPackageBuildFlags[name]:
+			for _, group := range perPackage[name] {
+				value := joinQuoted(group.args)
+				if group.pattern != "" {
+					value = group.pattern + "=" + value
+				}
+				buildFlags = append(buildFlags, fmt.Sprintf("-%s=%s", name, value))
+			}
+		case name == "tags":
+			buildFlags = append(buildFlags, "-tags="+strings.Join(tags, ","))
+		case boolBuildFlags[name] && values[name] == "true":
+			buildFlags = append(buildFlags, "-"+name)
+		default:
+			buildFlags = append(buildFlags, fmt.Sprintf("-%s=%s", name, values[name]))
+		}
+	}
+	return append(buildFlags, unknown...), nil
+}
+
+// parsePatternArgs splits the value of a per-package flag into its package pattern and arguments.
+func parsePatternArgs(name, value string) (string, []string, error) {
+	value = strings.TrimSpace(value)
+	pattern := ""
+	if value != "" && value[0] != '-' {
+		eq := strings.IndexByte(value, '=')
+		if eq < 0 {
+			return "", nil, fmt.Errorf("missing =<value> in -%s=%s, use <pattern>=<value>", name, value)
+		}
+		if eq == 0 {
+			return "", nil, fmt.Errorf("missing <pattern> in -%s=%s, use <pattern>=<value>", name, value)
+		}
+		pattern, value = strings.TrimSpace(value[:eq]), value[eq+1:]
+	}
+	args, err := splitQuoted(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid -%s=%s: %w", name, value, err)
+	}
+	return pattern, args, nil
+}
+
+// appendPatternArgs adds args to the group of pattern. As in go build, a flag without arguments,
+// such as -gcflags= or -gcflags=all=, resets the group.
+func appendPatternArgs(groups []*patternArgs, pattern string, args []string) []*patternArgs {
+	for _, group := range groups {
+		if group.pattern == pattern {
+			if len(args) == 0 {
+				group.args = nil
+			}
+			group.args = append(group.args, args...)
+			return groups
+		}
+	}
+	return append(groups, &patternArgs{pattern: pattern, args: args})
+}
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+// buildFlags returns the go flags of the config followed by the merged ExtraBuildFlags, which
+// may repeat a go flag with the same value but not contradict it.
+func (config *BuildConfig) buildFlags() ([]string, error) {
+	goFlags := config.goFlags()
+	extraFlags, err := mergeBuildFlags(config.ExtraBuildFlags, config.dynlink(), config.hostSettings)
+	if err != nil {
+		return nil, err
+	}
+	flags := append([]string{}, goFlags...)
+	for _, extraFlag := range extraFlags {
+		duplicate := false
+		for _, goFlag := range goFlags {
+			if flagName(goFlag) != flagName(extraFlag) {
+				continue
+			}
+			if goFlag != extraFlag {
+				return nil, fmt.Errorf("build flag %s conflicts with %s set by the build config", extraFlag, goFlag)
+			}
+			duplicate = true
+		}
+		if !duplicate {
+			flags = append(flags, extraFlag)
+		}
+	}
+	return flags, nil
+}
+
+// listFlags returns the build flags which must also be passed to go list, so that it reports
+// the files and dependencies go build will compile.
+func (config *BuildConfig) listFlags() ([]string, error) {
+	flags, err := config.buildFlags()
+	if err != nil {
+		return nil, err
+	}
+	listFlags := []string{}
+	for _, buildFlag := range flags {
+		if listBuildFlags[flagName(buildFlag)] {
+			listFlags = append(listFlags, buildFlag)
+		}
+	}
+	return listFlags, nil
+}
@@ -0,0 +1,65 @@
+package goloaderbuilder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeBuildFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   []string
+		dynlink bool
+		host    *HostSettings
+		want    []string
+		wantErr string
+	}{
+		{name: "empty", want: []string{}},
+		{name: "same pattern", flags: []string{"-gcflags=-N", "-gcflags=-l"}, want: []string{"-gcflags=-N -l"}},
+		{name: "patterns", flags: []string{"-gcflags=all=-N", "-gcflags", "example.com/x=-l"},
+			want: []string{"-gcflags=all=-N", "-gcflags=example.com/x=-l"}},
+		{name: "reset", flags: []string{"-gcflags=-N", "-gcflags="}, want: []string{"-gcflags="}},
+		{name: "reset pattern", flags: []string{"-gcflags=all=-N -l", "-gcflags=-m", "-gcflags=all="},
+			want: []string{"-gcflags=all=", "-gcflags=-m"}},
+		{name: "quoted", flags: []string{"-ldflags=-X 'main.v=a b'"}, want: []string{"-ldflags=-X 'main.v=a b'"}},
+		{name: "tags", flags: []string{"-tags=a,b", "-tags", "b c"}, want: []string{"-tags=a,b,c"}},
+		{name: "bool", flags: []string{"-trimpath", "-trimpath=true"}, want: []string{"-trimpath"}},
+		{name: "unknown", flags: []string{"-foo=bar", "-trimpath"}, want: []string{"-trimpath", "-foo=bar"}},
+		{name: "conflict", flags: []string{"-mod=mod", "-mod=vendor"}, wantErr: "conflicting build flags -mod=mod and -mod=vendor"},
+		{name: "rejected", flags: []string{"-o", "x.a"}, wantErr: "build flag -o is not supported"},
+		{name: "package", flags: []string{"./x"}, wantErr: "unexpected build argument"},
+		{name: "missing argument", flags: []string{"-tags"}, wantErr: "build flag -tags needs an argument"},
+		{name: "missing pattern", flags: []string{"-gcflags==-N"}, wantErr: "missing <pattern>"},
+		{name: "dynlink", dynlink: true, want: []string{"-gcflags=-dynlink"}},
+		{name: "dynlink pattern", flags: []string{"-gcflags=example.com/x=-N"}, dynlink: true,
+			want: []string{"-gcflags=-dynlink", "-gcflags=example.com/x=-dynlink -N"}},
+		{name: "dynlink unpatterned", flags: []string{"-gcflags=-l", "-gcflags=x=-N -dynlink"}, dynlink: true,
+			want: []string{"-gcflags=-dynlink -l", "-gcflags=x=-N -dynlink"}},
+		{name: "host", host: &HostSettings{Path: "host", Tags: []string{"foo", "bar"}, Trimpath: true},
+			want: []string{"-tags=foo,bar", "-trimpath"}},
+		{name: "host subset", flags: []string{"-tags=bar", "-race=false"}, host: &HostSettings{Path: "host", Tags: []string{"foo", "bar"}},
+			want: []string{"-tags=foo,bar", "-race=false"}},
+		{name: "host tag", flags: []string{"-tags=baz"}, host: &HostSettings{Path: "host", Tags: []string{"foo"}},
+			wantErr: "build tag baz is not set by the host executable host"},
+		{name: "host race", flags: []string{"-race=false"}, host: &HostSettings{Path: "host", Race: true},
+			wantErr: "build flag -race=false conflicts with the host executable host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeBuildFlags(tt.flags, tt.dynlink, tt.host)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mergeBuildFlags() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeBuildFlags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
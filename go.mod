@@ -1,3 +1,3 @@
 module github.com/pkujhd/goloaderbuilder
 
-go 1.11
+go 1.20
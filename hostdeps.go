@@ -0,0 +1,134 @@
+package goloaderbuilder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type SkippedDependency struct {
+	ImportPath string // dependency which was not built
+	Reason     string // why the host executable is expected to provide it
+}
+
+// nmLineRegexp matches a symbol printed by go tool nm: address, type and name. Undefined symbols
+// have no address, names may contain spaces, as in "type:struct { F int }".
+var nmLineRegexp = regexp.MustCompile(`^\s*([0-9a-f]*) ([A-Za-z?]) (.+)$`)
+
+// readSymbols lists the symbols defined and the symbols referenced but not defined by an archive
+// or an executable with go tool nm.
+func (config *BuildConfig) readSymbols(ctx context.Context, path string) (map[string]bool, []string, error) {
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	output, err := config.toolRunner().Tool(cmdCtx, &Invocation{Dir: config.WorkDir, Env: config.environ(), Args: []string{"nm", path}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read symbols of %s: %w", path, err)
+	}
+	defined := map[string]bool{}
+	var undefined []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		// symbols of archives holding several objects are prefixed with "archive(object):\t"
+		if tab := bytes.Index(line, []byte(":\t")); tab >= 0 {
+			line = line[tab+2:]
+		}
+		match := nmLineRegexp.FindSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if name := string(match[3]); match[2][0] == 'U' {
+			undefined = append(undefined, name)
+		} else {
+			defined[name] = true
+		}
+	}
+	return defined, undefined, scanner.Err()
+}
+
+// buildHostDependencies builds only the dependencies defining symbols which are referenced by the
+// root archives, or by dependencies built for them, and are missing from the host executable.
+// tasks build the archives of deps, the dependencies which are not built are returned as skipped.
+func buildHostDependencies(ctx context.Context, config *BuildConfig, roots []string, deps []*builtArchive, tasks []*buildTask) ([]*builtArchive, []SkippedDependency, error) {
+	hostSymbols, _, err := config.readSymbols(ctx, config.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(hostSymbols) == 0 {
+		return nil, nil, fmt.Errorf("host executable %s has no symbol table, it must not be stripped", config.Host)
+	}
+	index := map[string]int{}
+	for i, dep := range deps {
+		index[dep.pkgPath] = i
+	}
+
+	defined := map[string]bool{}
+	needed := map[string]bool{}
+	provided := map[string]map[string]bool{}
+	pending := roots
+	for len(pending) > 0 {
+		var undefined []string
+		for _, path := range pending {
+			symbols, refs, err := config.readSymbols(ctx, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			for name := range symbols {
+				defined[name] = true
+			}
+			undefined = append(undefined, refs...)
+		}
+
+		var batch []*buildTask
+		pending = nil
+		for _, name := range undefined {
+			pkgPath := symbolPackage(name)
+			if pkgPath == "" || needed[pkgPath] {
+				// symbols outside of packages are generated by the linker
+				continue
+			}
+			i, ok := index[pkgPath]
+			if !ok {
+				// the debug info of an archive refers to its source files by name, as "p/f.go"
+				if hostSymbols[name] || defined[name] || strings.HasSuffix(name, ".go") || strings.HasSuffix(name, ".s") {
+					continue
+				}
+				return nil, nil, fmt.Errorf("symbol %s is neither provided by the host executable %s nor by a dependency, package %s is not in the dependency graph", name, config.Host, pkgPath)
+			}
+			if hostSymbols[name] || defined[name] {
+				if provided[pkgPath] == nil {
+					provided[pkgPath] = map[string]bool{}
+				}
+				provided[pkgPath][name] = true
+				continue
+			}
+			needed[pkgPath] = true
+			batch = append(batch, tasks[i])
+			pending = append(pending, deps[i].path)
+		}
+		if err = runBuildTasks(ctx, batch, config.concurrency(), config.FailFast); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var built []*builtArchive
+	var skipped []SkippedDependency
+	for _, dep := range deps {
+		if needed[dep.pkgPath] {
+			built = append(built, dep)
+			continue
+		}
+		reason := "no symbol of the package is referenced"
+		if n := len(provided[dep.pkgPath]); n == 1 {
+			reason = "the host provides the referenced symbol"
+		} else if n > 1 {
+			reason = fmt.Sprintf("the host provides the %d referenced symbols", n)
+		}
+		skipped = append(skipped, SkippedDependency{ImportPath: dep.pkgPath, Reason: reason})
+	}
+	return built, skipped, nil
+}
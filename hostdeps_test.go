@@ -0,0 +1,34 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestReadSymbolsReplay(t *testing.T) {
+	config := &BuildConfig{WorkDir: "/work"}
+	fake := NewFakeToolchain()
+	fake.Recordings = []*FakeRecording{{
+		Subcommand: "tool",
+		Invocation: Invocation{Dir: "/work", Env: config.environ(), Args: []string{"nm", "/work/p.a"}},
+		Stdout: []byte("/work/p.a(go.o):\t    1a2b T example.com/m/p.Upper\n" +
+			"/work/p.a(go.o):\t         U strings.ToUpper\n" +
+			"/work/p.a(go.o):\t    1c00 R type:struct { F int }\n"),
+	}}
+	config.Toolchain = fake
+
+	defined, undefined, err := config.readSymbols(context.Background(), "/work/p.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"example.com/m/p.Upper": true, "type:struct { F int }": true}; !reflect.DeepEqual(defined, want) {
+		t.Errorf("defined = %v, want %v", defined, want)
+	}
+	if want := []string{"strings.ToUpper"}; !reflect.DeepEqual(undefined, want) {
+		t.Errorf("undefined = %v, want %v", undefined, want)
+	}
+	if len(fake.Calls) != 1 || fake.Calls[0].Subcommand != "tool" {
+		t.Errorf("calls = %+v, want a single go tool nm", fake.Calls)
+	}
+}
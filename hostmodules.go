@@ -0,0 +1,159 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"debug/buildinfo"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+type ModuleMismatch struct {
+	Path        string   // module path
+	Version     string   // version selected for the build, followed by "=> replacement" when replaced
+	HostVersion string   // version the host executable was built with, in the same form
+	Packages    []string // dependency packages of the module
+}
+
+type HostModuleReport struct {
+	Host       string           // host executable the module versions are compared with
+	Mismatches []ModuleMismatch // modules whose version differs from the host, as listed before pinning
+	Pinned     []ModuleVersion  // requirements pinned to the host version with PinHostModules
+	Unpinned   []string         // mismatching modules which cannot be pinned because the host or the build replaces them
+	ModFile    string           // copy of go.mod the pinned requirements were written to
+}
+
+// replacedVersion formats a module version as "version => replacement@version" when it is replaced.
+func replacedVersion(version, replacePath, replaceVersion string) string {
+	if replacePath == "" {
+		return version
+	}
+	version += " => " + replacePath
+	if replaceVersion != "" {
+		version += "@" + replaceVersion
+	}
+	return version
+}
+
+// hostModules reads the modules the host executable was built with, its main module is only
+// included when the build stamped it with a version.
+func hostModules(host string) (map[string]*debug.Module, error) {
+	info, err := buildinfo.ReadFile(host)
+	if err != nil {
+		return nil, fmt.Errorf("could not read build info of %s: %w", host, err)
+	}
+	modules := map[string]*debug.Module{}
+	if info.Main.Path != "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		modules[info.Main.Path] = &info.Main
+	}
+	for _, dep := range info.Deps {
+		modules[dep.Path] = dep
+	}
+	return modules, nil
+}
+
+// CompareHostModules compares the modules of the packages pkgs with the modules the host executable
+// was built with, and returns the modules both use at different versions, sorted by path.
+// Standard packages and modules the host does not contain are ignored.
+func CompareHostModules(host string, pkgs []*Package) ([]ModuleMismatch, error) {
+	modules, err := hostModules(host)
+	if err != nil {
+		return nil, err
+	}
+	return compareHostModules(modules, pkgs), nil
+}
+
+func compareHostModules(modules map[string]*debug.Module, pkgs []*Package) []ModuleMismatch {
+	mismatches := map[string]*ModuleMismatch{}
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.Module == nil || pkg.Module.Main {
+			continue
+		}
+		hostModule, ok := modules[pkg.Module.Path]
+		if !ok {
+			continue
+		}
+		version, hostVersion := pkg.Module.Version, hostModule.Version
+		if replace := pkg.Module.Replace; replace != nil {
+			version = replacedVersion(version, replace.Path, replace.Version)
+		}
+		if replace := hostModule.Replace; replace != nil {
+			hostVersion = replacedVersion(hostVersion, replace.Path, replace.Version)
+		}
+		if version == hostVersion {
+			continue
+		}
+		mismatch := mismatches[pkg.Module.Path]
+		if mismatch == nil {
+			mismatch = &ModuleMismatch{Path: pkg.Module.Path, Version: version, HostVersion: hostVersion}
+			mismatches[pkg.Module.Path] = mismatch
+		}
+		mismatch.Packages = append(mismatch.Packages, pkg.ImportPath)
+	}
+	result := make([]ModuleMismatch, 0, len(mismatches))
+	for _, mismatch := range mismatches {
+		sort.Strings(mismatch.Packages)
+		result = append(result, *mismatch)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// alignHostModules compares the dependency graph of a build with the modules of config.Host and,
+// with PinHostModules, requires the host versions of the mismatching modules in a copy of go.mod
+// passed with -modfile. It reports whether requirements were pinned and the graph must be listed again.
+func alignHostModules(ctx context.Context, config *BuildConfig, workDir string, graph map[string]*Package) (bool, error) {
+	modules, err := hostModules(config.Host)
+	if err != nil {
+		return false, err
+	}
+	pkgs := make([]*Package, 0, len(graph))
+	for _, pkg := range graph {
+		pkgs = append(pkgs, pkg)
+	}
+	report := &HostModuleReport{Host: config.Host, Mismatches: compareHostModules(modules, pkgs)}
+	config.hostModules = report
+	if !config.PinHostModules || len(report.Mismatches) == 0 {
+		return false, nil
+	}
+
+	buildReplaced := map[string]bool{}
+	for _, pkg := range pkgs {
+		if pkg.Module != nil && pkg.Module.Replace != nil {
+			buildReplaced[pkg.Module.Path] = true
+		}
+	}
+	var pins []string
+	for _, mismatch := range report.Mismatches {
+		hostModule := modules[mismatch.Path]
+		if hostModule.Replace != nil || buildReplaced[mismatch.Path] {
+			report.Unpinned = append(report.Unpinned, mismatch.Path)
+			continue
+		}
+		report.Pinned = append(report.Pinned, ModuleVersion{Path: mismatch.Path, Version: hostModule.Version})
+		pins = append(pins, mismatch.Path+"@"+hostModule.Version)
+	}
+	if len(pins) == 0 {
+		return false, nil
+	}
+
+	report.ModFile = config.modFile
+	if report.ModFile == "" {
+		goMod, err := config.goMod(ctx, workDir)
+		if err != nil {
+			return false, err
+		}
+		if report.ModFile, err = config.copyModFile(goMod); err != nil {
+			return false, err
+		}
+	}
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	args := append([]string{"-modfile=" + report.ModFile}, pins...)
+	if _, err = config.toolchain().Get(cmdCtx, &Invocation{Dir: workDir, Env: config.environ(), Args: args}); err != nil {
+		return false, fmt.Errorf("failed to pin host module versions %s: %w", strings.Join(pins, " "), err)
+	}
+	config.modFile = report.ModFile
+	return true, nil
+}
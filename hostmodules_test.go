@@ -0,0 +1,58 @@
+package goloaderbuilder
+
+import (
+	"reflect"
+	"runtime/debug"
+	"testing"
+)
+
+func TestCompareHostModules(t *testing.T) {
+	modules := map[string]*debug.Module{
+		"example.com/lib":   {Path: "example.com/lib", Version: "v1.0.0"},
+		"example.com/fork":  {Path: "example.com/fork", Version: "v1.0.0", Replace: &debug.Module{Path: "example.com/myfork", Version: "v1.0.1"}},
+		"example.com/local": {Path: "example.com/local", Version: "v1.0.0", Replace: &debug.Module{Path: "../local"}},
+		"example.com/same":  {Path: "example.com/same", Version: "v0.3.0"},
+	}
+	module := func(path, version string, replace *Module) *Module {
+		return &Module{Path: path, Version: version, Replace: replace}
+	}
+	tests := []struct {
+		name string
+		pkgs []*Package
+		want []ModuleMismatch
+	}{
+		{
+			name: "ignored",
+			pkgs: []*Package{
+				{ImportPath: "fmt", Standard: true},
+				{ImportPath: "example.com/host/p", Module: &Module{Path: "example.com/lib", Main: true}},
+				{ImportPath: "example.com/other", Module: module("example.com/other", "v1.0.0", nil)},
+				{ImportPath: "example.com/same/p", Module: module("example.com/same", "v0.3.0", nil)},
+				{ImportPath: "example.com/local/p", Module: module("example.com/local", "v1.0.0", &Module{Path: "../local"})},
+				{ImportPath: "example.com/nomodule"},
+			},
+			want: []ModuleMismatch{},
+		},
+		{
+			name: "mismatches",
+			pkgs: []*Package{
+				{ImportPath: "example.com/lib/b", Module: module("example.com/lib", "v1.1.0", nil)},
+				{ImportPath: "example.com/lib/a", Module: module("example.com/lib", "v1.1.0", nil)},
+				{ImportPath: "example.com/fork", Module: module("example.com/fork", "v1.0.0", nil)},
+				{ImportPath: "example.com/local", Module: module("example.com/local", "v1.0.0", &Module{Path: "example.com/local2", Version: "v1.0.0"})},
+			},
+			want: []ModuleMismatch{
+				{Path: "example.com/fork", Version: "v1.0.0", HostVersion: "v1.0.0 => example.com/myfork@v1.0.1", Packages: []string{"example.com/fork"}},
+				{Path: "example.com/lib", Version: "v1.1.0", HostVersion: "v1.0.0", Packages: []string{"example.com/lib/a", "example.com/lib/b"}},
+				{Path: "example.com/local", Version: "v1.0.0 => example.com/local2@v1.0.0", HostVersion: "v1.0.0 => ../local", Packages: []string{"example.com/local"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareHostModules(modules, tt.pkgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareHostModules() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
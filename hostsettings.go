@@ -0,0 +1,77 @@
+package goloaderbuilder
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"strings"
+)
+
+// HostSettings are the build settings of a host executable which archives loaded into it must share.
+type HostSettings struct {
+	Path       string   // host executable
+	Tags       []string // -tags the host was built with
+	Experiment string   // GOEXPERIMENT the host was built with, empty for the default experiments
+	Race       bool     // host was built with -race
+	Trimpath   bool     // host was built with -trimpath
+}
+
+// ReadHostSettings reads the build settings recorded in the build info of the executable at path.
+func ReadHostSettings(path string) (*HostSettings, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read build info of %s: %w", path, err)
+	}
+	settings := &HostSettings{Path: path}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "-tags":
+			for _, tag := range strings.Split(setting.Value, ",") {
+				if tag != "" {
+					settings.Tags = append(settings.Tags, tag)
+				}
+			}
+		case "GOEXPERIMENT":
+			settings.Experiment = setting.Value
+		case "-race":
+			settings.Race = setting.Value == "true"
+		case "-trimpath":
+			settings.Trimpath = setting.Value == "true"
+		}
+	}
+	return settings, nil
+}
+
+// loadHostSettings reads the settings of Host with InheritHostSettings, a GOEXPERIMENT of BuildEnv
+// must be the one of the host.
+func (config *BuildConfig) loadHostSettings() error {
+	if !config.InheritHostSettings {
+		config.hostSettings = nil
+		return nil
+	}
+	if config.Host == "" {
+		return fmt.Errorf("InheritHostSettings needs the host executable in Host")
+	}
+	if config.hostSettings == nil || config.hostSettings.Path != config.Host {
+		settings, err := ReadHostSettings(config.Host)
+		if err != nil {
+			return err
+		}
+		config.hostSettings = settings
+	}
+	for _, kv := range config.BuildEnv {
+		if !envKeyEqual(envKey(kv), "GOEXPERIMENT") {
+			continue
+		}
+		if experiment := strings.TrimPrefix(kv[len("GOEXPERIMENT"):], "="); experiment != config.hostSettings.Experiment {
+			return fmt.Errorf("build env %s conflicts with the host executable %s, built with GOEXPERIMENT=%s", kv, config.Host, config.hostSettings.Experiment)
+		}
+	}
+	return nil
+}
+
+func (config *BuildConfig) hostEnv() []string {
+	if config.hostSettings == nil {
+		return nil
+	}
+	return []string{"GOEXPERIMENT=" + config.hostSettings.Experiment}
+}
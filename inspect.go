@@ -0,0 +1,345 @@
+package goloaderbuilder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LinkMetadata is written next to a .goloader file, as <file>.json, when the archives of a build
+// are linked for a host executable.
+type LinkMetadata struct {
+	Created      time.Time // time the file was linked
+	GoVersion    string    // version of the go toolchain which compiled the archives
+	Platform     Platform  // target platform
+	PkgPath      string    // package path of the root package
+	Entry        string    // runnable symbol, empty unless the root package is main or a test main
+	Host         string    // host executable the archives were linked for
+	ManifestPath string    // manifest of the archives
+	BuildFlags   []string  // build flags of the archives
+
+	HostFingerprint *HostFingerprint `json:",omitempty"` // fingerprint of Host, checked by CheckHost before loading
+}
+
+func linkMetadataPath(goloaderPath string) string {
+	return goloaderPath + ".json"
+}
+
+// WriteLinkMetadata records the build of result next to the .goloader file it was linked into.
+func WriteLinkMetadata(config *BuildConfig, result *BuildResult, host, goloaderPath string) error {
+	buildFlags, err := config.buildFlags()
+	if err != nil {
+		return err
+	}
+	metadata := &LinkMetadata{
+		Created:      time.Now().UTC(),
+		GoVersion:    config.goEnv["GOVERSION"],
+		Platform:     config.Platform(),
+		PkgPath:      result.PkgPath,
+		Entry:        result.Entry,
+		Host:         host,
+		ManifestPath: result.ManifestPath,
+		BuildFlags:   buildFlags,
+	}
+	if host != "" {
+		if metadata.HostFingerprint, err = ReadHostFingerprint(host); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(metadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := linkMetadataPath(goloaderPath)
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write link metadata %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadLinkMetadata reads the metadata recorded next to a .goloader file, it returns nil without
+// error when the file was linked without metadata.
+func ReadLinkMetadata(goloaderPath string) (*LinkMetadata, error) {
+	data, err := os.ReadFile(linkMetadataPath(goloaderPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	metadata := &LinkMetadata{}
+	if err = json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("could not decode link metadata of %s: %w", goloaderPath, err)
+	}
+	return metadata, nil
+}
+
+type SymbolInfo struct {
+	Name     string // symbol name
+	Package  string // package path derived from the symbol name, empty for linker generated symbols
+	Code     bool   // symbol holds machine code
+	Size     int64  // size in bytes
+	Exported bool   // every identifier of the name is exported, such as pkg.F or pkg.(*T).M
+}
+
+type PackageInfo struct {
+	PkgPath  string // package path
+	Symbols  int    // number of defined symbols
+	CodeSize int64  // bytes of machine code
+	DataSize int64  // bytes of data, read-only data and type descriptors
+}
+
+type GoloaderInfo struct {
+	Metadata *LinkMetadata `json:",omitempty"` // recorded build metadata, nil when none was recorded
+	Packages []PackageInfo // packages with symbols in the file, sorted by package path
+	Symbols  []SymbolInfo  // symbols defined by the file, sorted by name
+	Exported []string      // names of the exported symbols
+	Host     []string      // symbols referenced by relocations but not defined, expected from the host
+}
+
+// symbolKindText is the objabi.SymKind of functions, goloader keeps the kinds of the compiler.
+const symbolKindText = 1
+
+// supportedGoloaderVersion is the goloader version required by cmd/goloaderbuilder, whose linker
+// layout InspectLinker reads: the linker holds obj.ObjSymbol values whose Reloc slices refer to
+// obj.Sym values. goloader exports no API to list the symbols of a linker.
+const supportedGoloaderVersion = "v0.0.0-20250930031008-a0b6f05e99be"
+
+// goloaderObjPkg is the package of the symbols of a goloader linker.
+var goloaderObjPkg = "github.com/pkujhd/goloader/obj"
+
+func layoutError(format string, args ...interface{}) error {
+	return fmt.Errorf("unsupported goloader linker layout, only goloader %s can be inspected: %s",
+		supportedGoloaderVersion, fmt.Sprintf(format, args...))
+}
+
+// objSymbolFields are the fields of obj.ObjSymbol read by InspectLinker.
+var objSymbolFields = map[string]reflect.Kind{"Name": reflect.String, "Kind": reflect.Int, "Data": reflect.Slice, "Reloc": reflect.Slice}
+
+// relocSymFields are the fields of the obj.Sym an obj.Reloc refers to read by InspectLinker.
+var relocSymFields = map[string]reflect.Kind{"Name": reflect.String}
+
+// symbolPrefix returns the package path prefix of a symbol name, as in "example.com/p.(*T).M",
+// escaped as the compiler writes it.
+func symbolPrefix(name string) string {
+	name = strings.TrimPrefix(name, "type:")
+	name = strings.TrimLeft(name, "*")
+	if strings.HasPrefix(name, "go:") || strings.HasPrefix(name, "go.") || strings.HasPrefix(name, "gclocals") || strings.Contains(name, ":") {
+		return ""
+	}
+	limit := len(name)
+	if i := strings.IndexAny(name, "[("); i >= 0 {
+		limit = i
+	}
+	slash := strings.LastIndex(name[:limit], "/")
+	dot := strings.Index(name[slash+1:limit], ".")
+	if dot < 0 {
+		return ""
+	}
+	prefix := name[:slash+1+dot]
+	// prefixes are escaped, a space or a brace belongs to the name of an unnamed type
+	if strings.ContainsAny(prefix, " {};,") {
+		return ""
+	}
+	return prefix
+}
+
+// symbolPackage returns the package path of a symbol name. The compiler escapes the bytes of a
+// package path which a symbol name can not hold as %xx, and the dots of its last element as %2e,
+// as in "gopkg.in/yaml%2ev3.Marshal".
+func symbolPackage(name string) string {
+	prefix := symbolPrefix(name)
+	if !strings.Contains(prefix, "%") {
+		return prefix
+	}
+	path := make([]byte, 0, len(prefix))
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] == '%' && i+2 < len(prefix) {
+			if b, err := strconv.ParseUint(prefix[i+1:i+3], 16, 8); err == nil {
+				path = append(path, byte(b))
+				i += 2
+				continue
+			}
+		}
+		path = append(path, prefix[i])
+	}
+	return string(path)
+}
+
+// exportedSymbol reports whether every identifier after the package path of name is exported.
+func exportedSymbol(name string) bool {
+	prefix := symbolPrefix(name)
+	if prefix == "" || !strings.HasPrefix(name, prefix+".") {
+		return false
+	}
+	rest := strings.NewReplacer("(*", "", "(", "", ")", "").Replace(name[len(prefix)+1:])
+	for _, ident := range strings.Split(rest, ".") {
+		r, _ := utf8.DecodeRuneInString(ident)
+		if !unicode.IsUpper(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// symbolScan collects the obj.ObjSymbol values reachable from a goloader linker, whose fields are
+// unexported.
+type symbolScan struct {
+	visited    map[uintptr]bool
+	defined    map[string]*SymbolInfo
+	referenced map[string]bool
+	found      bool
+}
+
+// checkFields returns an error when a field of t is missing or has another kind than in fields.
+func checkFields(t reflect.Type, fields map[string]reflect.Kind) error {
+	for name, kind := range fields {
+		if f, ok := t.FieldByName(name); !ok || f.Type.Kind() != kind {
+			return layoutError("%s has no %s field of kind %s", t, name, kind)
+		}
+	}
+	return nil
+}
+
+func (s *symbolScan) symbol(v reflect.Value) error {
+	if err := checkFields(v.Type(), objSymbolFields); err != nil {
+		return err
+	}
+	s.found = true
+	name, kind, data := v.FieldByName("Name").String(), v.FieldByName("Kind").Int(), v.FieldByName("Data")
+	size := int64(data.Len())
+	if f := v.FieldByName("Size"); f.IsValid() && f.Kind() >= reflect.Int && f.Kind() <= reflect.Int64 && f.Int() > size {
+		size = f.Int()
+	}
+	// symbols of kind Sxxx without data are only declared
+	if name != "" && (kind != 0 || size != 0) {
+		if info, ok := s.defined[name]; !ok || size > info.Size {
+			s.defined[name] = &SymbolInfo{Name: name, Package: symbolPackage(name), Code: kind == symbolKindText, Size: size, Exported: exportedSymbol(name)}
+		}
+	}
+	relocs := v.FieldByName("Reloc")
+	relocType := relocs.Type().Elem()
+	if relocType.Kind() != reflect.Struct {
+		return layoutError("%s.Reloc is not a slice of structs", v.Type())
+	}
+	if sym, ok := relocType.FieldByName("Sym"); !ok || sym.Type.Kind() != reflect.Ptr || sym.Type.Elem().Kind() != reflect.Struct {
+		return layoutError("%s has no Sym field pointing to a symbol", relocType)
+	} else if err := checkFields(sym.Type.Elem(), relocSymFields); err != nil {
+		return err
+	}
+	for i := 0; i < relocs.Len(); i++ {
+		if sym := relocs.Index(i).FieldByName("Sym"); !sym.IsNil() {
+			if target := sym.Elem().FieldByName("Name").String(); target != "" {
+				s.referenced[target] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (s *symbolScan) walk(v reflect.Value) error {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || s.visited[v.Pointer()] {
+			return nil
+		}
+		s.visited[v.Pointer()] = true
+		return s.walk(v.Elem())
+	case reflect.Interface:
+		if !v.IsNil() {
+			return s.walk(v.Elem())
+		}
+	case reflect.Struct:
+		if v.Type().PkgPath() == goloaderObjPkg && v.Type().Name() == "ObjSymbol" {
+			return s.symbol(v)
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if err := s.walk(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := s.walk(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := s.walk(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InspectLinker reports the packages and symbols of a linker deserialized from a .goloader file,
+// such as the *goloader.Linker returned by goloader.UnSerialize. The metadata recorded next to
+// goloaderPath is attached when goloaderPath is not empty.
+func InspectLinker(linker interface{}, goloaderPath string) (*GoloaderInfo, error) {
+	v := reflect.ValueOf(linker)
+	if !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, fmt.Errorf("no linker to inspect")
+	}
+	s := &symbolScan{visited: map[uintptr]bool{}, defined: map[string]*SymbolInfo{}, referenced: map[string]bool{}}
+	if err := s.walk(v); err != nil {
+		return nil, err
+	}
+	if !s.found {
+		return nil, layoutError("no %s.ObjSymbol found in %T", goloaderObjPkg, linker)
+	}
+
+	info := &GoloaderInfo{}
+	if goloaderPath != "" {
+		var err error
+		if info.Metadata, err = ReadLinkMetadata(goloaderPath); err != nil {
+			return nil, err
+		}
+	}
+	packages := map[string]*PackageInfo{}
+	for _, symbol := range s.defined {
+		info.Symbols = append(info.Symbols, *symbol)
+		if symbol.Exported {
+			info.Exported = append(info.Exported, symbol.Name)
+		}
+		pkg := packages[symbol.Package]
+		if pkg == nil {
+			pkg = &PackageInfo{PkgPath: symbol.Package}
+			packages[symbol.Package] = pkg
+		}
+		pkg.Symbols++
+		if symbol.Code {
+			pkg.CodeSize += symbol.Size
+		} else {
+			pkg.DataSize += symbol.Size
+		}
+	}
+	for name := range s.referenced {
+		if _, ok := s.defined[name]; !ok {
+			info.Host = append(info.Host, name)
+		}
+	}
+	for _, pkg := range packages {
+		info.Packages = append(info.Packages, *pkg)
+	}
+	sort.Slice(info.Symbols, func(i, j int) bool { return info.Symbols[i].Name < info.Symbols[j].Name })
+	sort.Slice(info.Packages, func(i, j int) bool { return info.Packages[i].PkgPath < info.Packages[j].PkgPath })
+	sort.Strings(info.Exported)
+	sort.Strings(info.Host)
+	return info, nil
+}
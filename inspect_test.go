@@ -0,0 +1,129 @@
+package goloaderbuilder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSymbolPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		pkg      string
+		exported bool
+	}{
+		{"example.com/p.F", "example.com/p", true},
+		{"example.com/p.f", "example.com/p", false},
+		{"example.com/p.(*T).M", "example.com/p", true},
+		{"example.com/p.(*T).m", "example.com/p", false},
+		{"example.com/p.F[go.shape.int]", "example.com/p", false},
+		{"example.com/app/x%2ev2.Counter", "example.com/app/x.v2", true},
+		{"gopkg.in/yaml%2ev3.Marshal", "gopkg.in/yaml.v3", true},
+		{"example.com/a%20b.F", "example.com/a b", true},
+		{"type:*example.com/p.T", "example.com/p", false},
+		{"type:example.com/p.T", "example.com/p", false},
+		{"runtime.memequal", "runtime", false},
+		{"main.main", "main", false},
+		{"type:[]unsafe.Pointer", "", false},
+		{"type:map[string]example.com/p.T", "", false},
+		{"type:struct { F example.com/p.T }", "", false},
+		{"type:func(example.com/p.T)", "", false},
+		{"go:buildid", "", false},
+		{"go.shape.int", "", false},
+		{"gclocals·g2BeySu+wFnoycgXfElmcg==", "", false},
+		{"_cgo_init", "", false},
+	}
+	for _, tt := range tests {
+		if got := symbolPackage(tt.name); got != tt.pkg {
+			t.Errorf("symbolPackage(%q) = %q, want %q", tt.name, got, tt.pkg)
+		}
+		if got := exportedSymbol(tt.name); got != tt.exported {
+			t.Errorf("exportedSymbol(%q) = %t, want %t", tt.name, got, tt.exported)
+		}
+	}
+}
+
+// TestInspectLinker inspects a linker built from types mirroring the layout of the supported
+// goloader version, obj.ObjSymbol, obj.Reloc and obj.Sym.
+func TestInspectLinker(t *testing.T) {
+	type Sym struct {
+		Name   string
+		Offset int
+	}
+	type Reloc struct {
+		Offset int
+		Sym    *Sym
+		Size   int
+	}
+	type ObjSymbol struct {
+		Name  string
+		Kind  int
+		Func  interface{}
+		Data  []byte
+		Reloc []Reloc
+	}
+	type linker struct {
+		symMap  map[string]*Sym
+		objsyms map[string]*ObjSymbol
+	}
+	defer func(objPkg string) { goloaderObjPkg = objPkg }(goloaderObjPkg)
+	goloaderObjPkg = reflect.TypeOf(ObjSymbol{}).PkgPath()
+
+	add := &ObjSymbol{Name: "example.com/p.Add", Kind: symbolKindText, Data: make([]byte, 16),
+		Reloc: []Reloc{{Sym: &Sym{Name: "example.com/p.total"}}, {Sym: &Sym{Name: "runtime.morestack_noctxt"}}}}
+	total := &ObjSymbol{Name: "example.com/p.total", Kind: 2, Data: make([]byte, 8)}
+	l := &linker{objsyms: map[string]*ObjSymbol{add.Name: add, total.Name: total}}
+
+	info, err := InspectLinker(l, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSymbols := []SymbolInfo{
+		{Name: "example.com/p.Add", Package: "example.com/p", Code: true, Size: 16, Exported: true},
+		{Name: "example.com/p.total", Package: "example.com/p", Size: 8},
+	}
+	if !reflect.DeepEqual(info.Symbols, wantSymbols) {
+		t.Errorf("Symbols = %+v, want %+v", info.Symbols, wantSymbols)
+	}
+	wantPackages := []PackageInfo{{PkgPath: "example.com/p", Symbols: 2, CodeSize: 16, DataSize: 8}}
+	if !reflect.DeepEqual(info.Packages, wantPackages) {
+		t.Errorf("Packages = %+v, want %+v", info.Packages, wantPackages)
+	}
+	if want := []string{"example.com/p.Add"}; !reflect.DeepEqual(info.Exported, want) {
+		t.Errorf("Exported = %q, want %q", info.Exported, want)
+	}
+	if want := []string{"runtime.morestack_noctxt"}; !reflect.DeepEqual(info.Host, want) {
+		t.Errorf("Host = %q, want %q", info.Host, want)
+	}
+}
+
+func TestInspectLinkerLayout(t *testing.T) {
+	type ObjSymbol struct {
+		Name string
+		Kind string
+	}
+	type linker struct {
+		objsyms map[string]*ObjSymbol
+	}
+	defer func(objPkg string) { goloaderObjPkg = objPkg }(goloaderObjPkg)
+	goloaderObjPkg = reflect.TypeOf(ObjSymbol{}).PkgPath()
+
+	tests := []struct {
+		name   string
+		linker interface{}
+		want   string
+	}{
+		{"nil", nil, "no linker to inspect"},
+		{"nil pointer", (*linker)(nil), "no linker to inspect"},
+		{"no symbols", &struct{ symbols []string }{}, "only goloader " + supportedGoloaderVersion + " can be inspected: no"},
+		{"other layout", &linker{objsyms: map[string]*ObjSymbol{"p.F": {Name: "p.F", Kind: "text"}}},
+			"only goloader " + supportedGoloaderVersion + " can be inspected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := InspectLinker(tt.linker, ""); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("InspectLinker() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type MainPathMode int
+
+const (
+	MainPathDir  MainPathMode = iota // import path of the package directory, such as example.com/cmd/tool
+	MainPathHash                     // "main_" followed by a hash of the package sources
+)
+
+// DefaultMainEntry is the exported function main.main is renamed to when MainEntry is not set.
+const DefaultMainEntry = "GoloaderMain"
+
+func (config *BuildConfig) mainEntry() string {
+	if config.MainEntry != "" {
+		return config.MainEntry
+	}
+	return DefaultMainEntry
+}
+
+// mainPkgPath returns the unique package path given to the main package pkg, PkgPath is only
+// used when it is not "main" so that main packages never share their symbols.
+func (config *BuildConfig) mainPkgPath(pkg *Package) (string, error) {
+	if config.PkgPath != "" && config.PkgPath != "main" {
+		return config.PkgPath, nil
+	}
+	if config.MainPathMode == MainPathHash {
+		h := sha256.New()
+		for _, file := range sourceFiles(pkg) {
+			if err := hashFile(h, config.sourcePath(filepath.Join(pkg.Dir, file))); err != nil {
+				return "", err
+			}
+		}
+		return "main_" + hex.EncodeToString(h.Sum(nil))[:16], nil
+	}
+	if pkg.ImportPath != "command-line-arguments" {
+		return pkg.ImportPath, nil
+	}
+	if path := config.dirImportPath(pkg); path != "" {
+		return path, nil
+	}
+	return filepath.Base(pkg.Dir), nil
+}
+
+// packageName turns the last element of pkgPath into a package name.
+func packageName(pkgPath string) string {
+	name := []rune(path.Base(pkgPath))
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || string(name) == "main" {
+		return "goloader_main"
+	}
+	return string(name)
+}
+
+type sourceEdit struct {
+	offset int
+	old    string
+	new    string
+}
+
+// rewriteMainFile renames the package clause of a main package file to name, and the function
+// main with every reference to it to entry. References from other files of the package are
+// left unresolved by the parser, there is no other package level main they could refer to.
+func rewriteMainFile(filename string, src []byte, name, entry string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	if obj := file.Scope.Lookup(entry); obj != nil {
+		return nil, fmt.Errorf("%s: %s is already declared, set MainEntry to another name", fset.Position(obj.Pos()), entry)
+	}
+	edits := []sourceEdit{{offset: fset.Position(file.Name.Pos()).Offset, old: file.Name.Name, new: name}}
+	rename := func(ident *ast.Ident) {
+		edits = append(edits, sourceEdit{offset: fset.Position(ident.Pos()).Offset, old: ident.Name, new: entry})
+	}
+	mainObj := file.Scope.Lookup("main")
+	ast.Inspect(file, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok && ident.Name == "main" && ident != file.Name && mainObj != nil && ident.Obj == mainObj {
+			rename(ident)
+		}
+		return true
+	})
+	for _, ident := range file.Unresolved {
+		if ident.Name == "main" {
+			rename(ident)
+		}
+	}
+	return applyEdits(src, edits)
+}
+
+func applyEdits(src []byte, edits []sourceEdit) ([]byte, error) {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].offset > edits[j].offset })
+	out := append([]byte{}, src...)
+	for _, edit := range edits {
+		if edit.offset+len(edit.old) > len(out) || string(out[edit.offset:edit.offset+len(edit.old)]) != edit.old {
+			return nil, fmt.Errorf("unexpected source at offset %d", edit.offset)
+		}
+		out = append(out[:edit.offset], append([]byte(edit.new), out[edit.offset+len(edit.old):]...)...)
+	}
+	return out, nil
+}
+
+// prepareMain turns the main package pkg into a loadable package. The go command builds main
+// packages into executables, so their Go files are overlaid with a copy in package name derived
+// from the unique package path, with main renamed to the exported MainEntry, and listed again.
+// A package directory is built as a whole, so that its assembly, header, syso and embedded
+// files are built along with the rewritten Go files; the go command only accepts Go files
+// when the files of a package are named on the command line.
+func prepareMain(ctx context.Context, config *BuildConfig, pkg *Package) (*Package, map[string]*Package, error) {
+	pkgPath, err := config.mainPkgPath(pkg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not derive package path of main package %s: %w", pkg.Dir, err)
+	}
+	name := packageName(pkgPath)
+	files := map[string][]byte{}
+	var goFiles []string
+	for _, file := range append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...) {
+		filename := filepath.Join(pkg.Dir, file)
+		src, err := os.ReadFile(config.sourcePath(filename))
+		if err != nil {
+			return nil, nil, err
+		}
+		if files[file], err = rewriteMainFile(filename, src, name, config.mainEntry()); err != nil {
+			return nil, nil, fmt.Errorf("could not rewrite main package: %w", err)
+		}
+		goFiles = append(goFiles, filename)
+	}
+
+	if config.overlay == nil {
+		h := sha256.New()
+		for _, file := range goFiles {
+			fmt.Fprintf(h, "%s %s\n", file, files[filepath.Base(file)])
+		}
+		config.overlay = config.newOverlay(hex.EncodeToString(h.Sum(nil)))
+	}
+	if err = config.overlay.add(pkg.Dir, files); err != nil {
+		return nil, nil, err
+	}
+
+	buildPaths := goFiles
+	if pkg.ImportPath != "command-line-arguments" {
+		buildPaths = []string{pkg.Dir}
+	}
+	config.PkgPath = pkgPath
+	config.BuildPaths = buildPaths
+	config.entry = pkgPath + "." + config.mainEntry()
+	mainPkg, graph, err := getPkg(ctx, config, config.WorkDir, buildPaths...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mainPkg.Name == "main" {
+		return nil, nil, fmt.Errorf("main package %s could not be renamed", strings.Join(buildPaths, " "))
+	}
+	return mainPkg, graph, nil
+}
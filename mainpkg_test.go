@@ -0,0 +1,58 @@
+package goloaderbuilder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteMainFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "main",
+			src:  "package main\n\nfunc main() {\n\trun(main)\n}\n\nfunc run(f func()) {}\n",
+			want: "package p\n\nfunc Main() {\n\trun(Main)\n}\n\nfunc run(f func()) {}\n",
+		},
+		{
+			name: "other file",
+			src:  "package main\n\nfunc init() {\n\tdefer main()\n}\n",
+			want: "package p\n\nfunc init() {\n\tdefer Main()\n}\n",
+		},
+		{
+			name: "shadowed",
+			src:  "package main\n\nfunc main() {}\n\nfunc f() {\n\tmain := 1\n\t_ = main\n}\n",
+			want: "package p\n\nfunc Main() {}\n\nfunc f() {\n\tmain := 1\n\t_ = main\n}\n",
+		},
+		{
+			name:    "declared",
+			src:     "package main\n\nvar Main int\n\nfunc main() {}\n",
+			wantErr: "Main is already declared",
+		},
+		{
+			name:    "syntax",
+			src:     "package main\n\nfunc main() {\n",
+			wantErr: "expected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteMainFile("main.go", []byte(tt.src), "p", "Main")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("rewriteMainFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("rewriteMainFile() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package goloaderbuilder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Manifest struct {
+	Created      time.Time       // time the manifest was written
+	GoVersion    string          // version of the go toolchain
+	Platform     Platform        // target platform
+	Root         ManifestEntry   // archive of the root package
+	Dependencies []ManifestEntry // archives of the dependencies, in build order
+}
+
+type ManifestEntry struct {
+	ImportPath    string            // import path reported by go list
+	PkgPath       string            // package path passed to goloader
+	Path          string            // archive path
+	ModulePath    string            // path of the module containing the package, empty for std and GOPATH packages
+	ModuleVersion string            // version of the module containing the package, empty for the main module
+	SHA256        string            // hex encoded SHA-256 of the archive
+	Size          int64             // size of the archive in bytes
+	GoVersion     string            // version of the go toolchain which compiled the archive
+	BuildFlags    []string          // build flags the archive was compiled with
+	Cached        bool              // archive was reused from the build cache
+	Imports       []string          // package paths of the imported archives, after vendor and ImportMap translation
+	ImportMap     map[string]string // source import paths which compiled against a different package path
+	HostObjects   []string          // host objects extracted from a cgo archive
+	CgoLDFLAGS    []string          // linker flags of a cgo package
+}
+
+func (config *BuildConfig) manifestPath() string {
+	if config.ManifestPath != "" {
+		return config.ManifestPath
+	}
+	return strings.TrimSuffix(config.TargetPath, ".a") + ".manifest.json"
+}
+
+// newManifestEntry describes archive, its build flags are the ones of the config it was built with.
+func newManifestEntry(archive *builtArchive) (ManifestEntry, error) {
+	config := archive.config
+	buildFlags, err := config.buildFlags()
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	entry := ManifestEntry{
+		ImportPath: archive.pkg.ImportPath,
+		PkgPath:    archive.pkgPath,
+		Path:       archive.path,
+		GoVersion:  config.goEnv["GOVERSION"],
+		BuildFlags: buildFlags,
+		Cached:     archive.cached,
+		Imports:    resolvedImports(archive.pkg),
+		ImportMap:  archive.pkg.ImportMap,
+	}
+	if archive.cgo != nil {
+		entry.HostObjects = archive.cgo.Objects
+		entry.CgoLDFLAGS = archive.cgo.LDFLAGS
+	}
+	if module := archive.pkg.Module; module != nil {
+		if module.Replace != nil && module.Replace.Version != "" {
+			module = module.Replace
+		}
+		entry.ModulePath = module.Path
+		entry.ModuleVersion = module.Version
+	}
+
+	f, err := os.Open(archive.path)
+	if err != nil {
+		return entry, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	entry.Size, err = io.Copy(h, f)
+	if err != nil {
+		return entry, fmt.Errorf("could not hash %s: %w", archive.path, err)
+	}
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return entry, nil
+}
+
+func newManifest(config *BuildConfig, root *builtArchive, deps []*builtArchive) (*Manifest, error) {
+	manifest := &Manifest{
+		Created:   time.Now().UTC(),
+		GoVersion: config.goEnv["GOVERSION"],
+		Platform:  config.Platform(),
+	}
+	var err error
+	if manifest.Root, err = newManifestEntry(root); err != nil {
+		return nil, err
+	}
+	for _, dep := range deps {
+		entry, err := newManifestEntry(dep)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Dependencies = append(manifest.Dependencies, entry)
+	}
+	return manifest, nil
+}
+
+func (manifest *Manifest) write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write manifest at %s: %w", path, err)
+	}
+	return nil
+}
+
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,29 @@
+package goloaderbuilder
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestBuildFlags(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"root.a": "root", "dep.a": "dependency"})
+	rootConfig := &BuildConfig{GOOS: "linux", GOARCH: "arm64", ExtraBuildFlags: []string{"-trimpath"}}
+	depConfig := &BuildConfig{GOOS: "linux", GOARCH: "arm64", Dynlink: DynlinkOff}
+	root := &builtArchive{config: rootConfig, pkg: &Package{ImportPath: "example.com/m"}, path: filepath.Join(dir, "root.a")}
+	dep := &builtArchive{config: depConfig, pkg: &Package{ImportPath: "fmt", Standard: true}, path: filepath.Join(dir, "dep.a"), cached: true}
+	manifest, err := newManifest(rootConfig, root, []*builtArchive{dep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := strings.Join(manifest.Root.BuildFlags, " "); !strings.Contains(flags, "-dynlink") || !strings.Contains(flags, "-trimpath") {
+		t.Errorf("root BuildFlags = %v, want -dynlink and -trimpath", manifest.Root.BuildFlags)
+	}
+	if flags := strings.Join(manifest.Dependencies[0].BuildFlags, " "); strings.Contains(flags, "-dynlink") || strings.Contains(flags, "-trimpath") {
+		t.Errorf("dependency BuildFlags = %v, want neither -dynlink nor -trimpath", manifest.Dependencies[0].BuildFlags)
+	}
+	if entry := manifest.Dependencies[0]; !entry.Cached || entry.Size != int64(len("dependency")) {
+		t.Errorf("dependency entry = %+v, want a cached archive of %d bytes", entry, len("dependency"))
+	}
+}
@@ -0,0 +1,187 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type OfflineError struct {
+	Missing []string        // modules (path@version) or, when the module is unknown, import paths unavailable offline
+	Errors  []*PackageError // errors reported by go list
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("offline build is missing %d module(s), export them with ExportModules on a connected machine:\n\t%s",
+		len(e.Missing), strings.Join(e.Missing, "\n\t"))
+}
+
+// offlineEnv returns the environment forcing the go command to use only the local module
+// cache, or the file:// mirror in MirrorDir, made absolute by initConfig.
+func (config *BuildConfig) offlineEnv() []string {
+	if !config.Offline {
+		return nil
+	}
+	proxy := "off"
+	if config.MirrorDir != "" {
+		proxy = "file://" + filepath.ToSlash(config.MirrorDir)
+	}
+	env := []string{"GOPROXY=" + proxy, "GOSUMDB=off"}
+	if config.ModMode != ModDefault {
+		return env
+	}
+	// -mod=mod is added to the GOFLAGS the go command would see, unless they already set -mod
+	for _, flag := range strings.Fields(config.envGoFlags) {
+		if strings.HasPrefix(flag, "-mod=") || strings.HasPrefix(flag, "--mod=") {
+			return env
+		}
+	}
+	return append(env, strings.TrimSpace("GOFLAGS="+config.envGoFlags+" -mod=mod"))
+}
+
+// loadGoFlags reads the effective GOFLAGS, including the ones set with go env -w, before
+// offlineEnv overrides them.
+func (config *BuildConfig) loadGoFlags(ctx context.Context) error {
+	if !config.Offline {
+		return nil
+	}
+	online := *config
+	online.Offline = false
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	values, err := goEnv(cmdCtx, config.toolchain(), config.WorkDir, online.environ(), "GOFLAGS")
+	if err != nil {
+		return err
+	}
+	config.envGoFlags = values["GOFLAGS"]
+	return nil
+}
+
+var unavailableModuleRegexp = regexp.MustCompile(`([^\s:"']+@[^\s:"']+): (?:module lookup disabled|reading file://|verifying module)`)
+
+// offlineError collects the modules go list could not load for pkg without network access.
+func offlineError(pkg *Package) *OfflineError {
+	offlineErr := &OfflineError{}
+	seen := map[string]bool{}
+	add := func(missing string) {
+		if !seen[missing] {
+			seen[missing] = true
+			offlineErr.Missing = append(offlineErr.Missing, missing)
+		}
+	}
+	errs := pkg.DepsErrors
+	if pkg.Error != nil {
+		errs = append([]*PackageError{pkg.Error}, errs...)
+	}
+	for _, pkgErr := range errs {
+		offlineErr.Errors = append(offlineErr.Errors, pkgErr)
+		if match := unavailableModuleRegexp.FindStringSubmatch(pkgErr.Err); match != nil {
+			add(match[1])
+		} else if match := missingPackageRegexp.FindStringSubmatch(pkgErr.Err); match != nil {
+			add(match[1] + match[2])
+		} else {
+			add(pkgErr.Err)
+		}
+	}
+	sort.Strings(offlineErr.Missing)
+	return offlineErr
+}
+
+type downloadedModule struct {
+	Path     string // module path
+	Version  string // module version
+	Error    string // error loading module
+	Info     string // absolute path to cached .info file
+	GoMod    string // absolute path to cached .mod file
+	Zip      string // absolute path to cached .zip file
+	GoModSum string // checksum for go.mod (as in go.sum)
+}
+
+func ExportModules(config *BuildConfig, dir string) ([]ModuleVersion, error) {
+	return ExportModulesContext(context.Background(), config, dir)
+}
+
+// ExportModulesContext copies every module in the build list of the main module in
+// config.WorkDir from the local module cache into dir, laid out as a GOPROXY so that the
+// same packages can be built offline on another machine with MirrorDir set to dir.
+func ExportModulesContext(ctx context.Context, config *BuildConfig, dir string) ([]ModuleVersion, error) {
+	workDir := config.WorkDir
+	if workDir == "" {
+		workDir = "."
+	}
+	values, err := goEnv(ctx, config.toolchain(), workDir, config.environ(), "GOMODCACHE")
+	if err != nil {
+		return nil, err
+	}
+	downloadDir := filepath.Join(values["GOMODCACHE"], "cache", "download")
+
+	offline := *config
+	offline.Offline = true
+	offline.MirrorDir = ""
+	if err = offline.loadGoFlags(ctx); err != nil {
+		return nil, err
+	}
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	output, err := config.toolchain().Mod(cmdCtx, &Invocation{Dir: workDir, Env: offline.environ(), Args: []string{"download", "-json", "all"}})
+	if err != nil && len(output) == 0 {
+		return nil, fmt.Errorf("failed to list modules in the local module cache: %w", err)
+	}
+
+	var exported []ModuleVersion
+	var missing []string
+	versions := map[string][]string{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		module := downloadedModule{}
+		if err = decoder.Decode(&module); err != nil {
+			return nil, fmt.Errorf("failed to decode response of 'go mod download -json all': %w", err)
+		}
+		if module.Error != "" {
+			missing = append(missing, module.Path+"@"+module.Version)
+			continue
+		}
+		for _, file := range []string{module.Info, module.GoMod, module.Zip, strings.TrimSuffix(module.Zip, ".zip") + ".ziphash"} {
+			if file == "" {
+				continue
+			}
+			rel, err := filepath.Rel(downloadDir, file)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return nil, fmt.Errorf("module file %s is outside of the module cache %s", file, downloadDir)
+			}
+			if err = copyFile(filepath.Join(dir, rel), file); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("could not export %s: %w", file, err)
+			}
+		}
+		rel, _ := filepath.Rel(downloadDir, filepath.Dir(module.GoMod))
+		versions[rel] = append(versions[rel], module.Version)
+		exported = append(exported, ModuleVersion{Path: module.Path, Version: module.Version})
+	}
+
+	for versionDir, list := range versions {
+		listPath := filepath.Join(dir, versionDir, "list")
+		if data, err := os.ReadFile(listPath); err == nil {
+			list = append(list, strings.Fields(string(data))...)
+		}
+		sort.Strings(list)
+		unique := list[:0]
+		for i, version := range list {
+			if i == 0 || version != list[i-1] {
+				unique = append(unique, version)
+			}
+		}
+		if err = os.WriteFile(listPath, []byte(strings.Join(unique, "\n")+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if len(missing) > 0 {
+		return exported, fmt.Errorf("modules missing from the local module cache, run go mod download first:\n\t%s", strings.Join(missing, "\n\t"))
+	}
+	return exported, nil
+}
@@ -0,0 +1,112 @@
+package goloaderbuilder
+
+import (
+	"path/filepath"
+	"runtime"
+)
+
+type Platform struct {
+	GOOS      string // target operating system
+	GOARCH    string // target architecture
+	Microarch string // target microarchitecture, such as "v3" for GOAMD64 or "v8.2" for GOARM64
+}
+
+// microarchEnv maps an architecture to the environment variable selecting its microarchitecture.
+var microarchEnv = map[string]string{
+	"386":      "GO386",
+	"amd64":    "GOAMD64",
+	"arm":      "GOARM",
+	"arm64":    "GOARM64",
+	"mips":     "GOMIPS",
+	"mipsle":   "GOMIPS",
+	"mips64":   "GOMIPS64",
+	"mips64le": "GOMIPS64",
+	"ppc64":    "GOPPC64",
+	"ppc64le":  "GOPPC64",
+	"riscv64":  "GORISCV64",
+	"wasm":     "GOWASM",
+}
+
+func (p Platform) String() string {
+	name := p.GOOS + "_" + p.GOARCH
+	if p.Microarch != "" {
+		name += "_" + p.Microarch
+	}
+	return name
+}
+
+// DynlinkMode selects whether archives are compiled with -dynlink.
+type DynlinkMode int
+
+const (
+	DynlinkAuto DynlinkMode = iota // -dynlink only when the target platform requires it
+	DynlinkOn                      // always compile with -dynlink
+	DynlinkOff                     // never compile with -dynlink
+)
+
+// DynlinkRequired reports whether archives for the platform must be compiled with -dynlink
+// to be loadable by goloader, position independent code is not needed on linux/amd64.
+func (p Platform) DynlinkRequired() bool {
+	return !(p.GOOS == "linux" && p.GOARCH == "amd64")
+}
+
+func (config *BuildConfig) platformEnv() []string {
+	var env []string
+	if config.GOOS != "" {
+		env = append(env, "GOOS="+config.GOOS)
+	}
+	if config.GOARCH != "" {
+		env = append(env, "GOARCH="+config.GOARCH)
+	}
+	if config.Microarch != "" {
+		goarch := config.GOARCH
+		if goarch == "" {
+			goarch = runtime.GOARCH
+			if config.goEnv != nil && config.goEnv["GOARCH"] != "" {
+				goarch = config.goEnv["GOARCH"]
+			}
+		}
+		if key, ok := microarchEnv[goarch]; ok {
+			env = append(env, key+"="+config.Microarch)
+		}
+	}
+	return env
+}
+
+// Platform returns the target platform of the build. Fields left empty in the config are
+// taken from go env once the config has been initialized by a build, or from the host otherwise.
+func (config *BuildConfig) Platform() Platform {
+	p := Platform{GOOS: config.GOOS, GOARCH: config.GOARCH, Microarch: config.Microarch}
+	if p.GOOS == "" {
+		p.GOOS = config.goEnv["GOOS"]
+	}
+	if p.GOARCH == "" {
+		p.GOARCH = config.goEnv["GOARCH"]
+	}
+	if p.GOOS == "" {
+		p.GOOS = runtime.GOOS
+	}
+	if p.GOARCH == "" {
+		p.GOARCH = runtime.GOARCH
+	}
+	if p.Microarch == "" {
+		p.Microarch = config.goEnv[microarchEnv[p.GOARCH]]
+	}
+	return p
+}
+
+// PlatformDir returns the directory under TargetDir holding the outputs for the target platform.
+func (config *BuildConfig) PlatformDir() string {
+	return filepath.Join(config.TargetDir, config.Platform().String())
+}
+
+func (config *BuildConfig) dynlink() bool {
+	switch config.Dynlink {
+	case DynlinkOn:
+		return true
+	case DynlinkOff:
+		return false
+	default:
+		return config.Platform().DynlinkRequired()
+	}
+}
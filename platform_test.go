@@ -0,0 +1,40 @@
+package goloaderbuilder
+
+import "testing"
+
+func TestPlatformDynlink(t *testing.T) {
+	tests := []struct {
+		goos, goarch string
+		mode         DynlinkMode
+		want         bool
+	}{
+		{goos: "linux", goarch: "amd64", mode: DynlinkAuto, want: false},
+		{goos: "linux", goarch: "arm64", mode: DynlinkAuto, want: true},
+		{goos: "darwin", goarch: "amd64", mode: DynlinkAuto, want: true},
+		{goos: "linux", goarch: "amd64", mode: DynlinkOn, want: true},
+		{goos: "linux", goarch: "arm64", mode: DynlinkOff, want: false},
+	}
+	for _, tt := range tests {
+		config := &BuildConfig{GOOS: tt.goos, GOARCH: tt.goarch, Dynlink: tt.mode}
+		if got := config.dynlink(); got != tt.want {
+			t.Errorf("%s/%s with mode %d: dynlink = %v, want %v", tt.goos, tt.goarch, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformString(t *testing.T) {
+	config := &BuildConfig{GOOS: "darwin", GOARCH: "arm64", Microarch: "v8.0", TargetDir: "/target"}
+	if got, want := config.PlatformDir(), "/target/darwin_arm64_v8.0"; got != want {
+		t.Errorf("PlatformDir = %s, want %s", got, want)
+	}
+	env := config.platformEnv()
+	want := []string{"GOOS=darwin", "GOARCH=arm64", "GOARM64=v8.0"}
+	if len(env) != len(want) {
+		t.Fatalf("platformEnv = %v, want %v", env, want)
+	}
+	for i := range want {
+		if env[i] != want[i] {
+			t.Errorf("platformEnv = %v, want %v", env, want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows || plan9 || js || wasip1
+
+package goloaderbuilder
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {
+}
@@ -0,0 +1,16 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package goloaderbuilder
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// kill the whole process group, go build forks compile/asm/cgo children
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,215 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type ResolveMode int
+
+const (
+	ResolveModfile ResolveMode = iota // resolve in a copy of go.mod and go.sum passed with -modfile, user files are never touched
+	ResolveModify                     // run go mod download and go get against the user's go.mod
+	ResolveStrict                     // never resolve, fail with the dependency errors reported by go list
+)
+
+type ModuleVersion struct {
+	Path    string // module path
+	Version string // module version
+}
+
+type ResolveReport struct {
+	GoMod   string          // go.mod of the main module
+	ModFile string          // go.mod actually modified, a copy of GoMod in ResolveModfile mode
+	Missing []string        // import paths which could not be found before resolving
+	Added   []ModuleVersion // requirements added while resolving
+	Updated []ModuleVersion // requirements whose version changed while resolving, with their new version
+}
+
+type DependencyError struct {
+	ImportPath string          // package whose dependencies could not be loaded
+	Errors     []*PackageError // errors reported by go list
+}
+
+func (e *DependencyError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, pkgErr := range e.Errors {
+		msg := pkgErr.Err
+		if pkgErr.Pos != "" && !strings.HasPrefix(msg, pkgErr.Pos) {
+			msg = pkgErr.Pos + ": " + msg
+		}
+		msgs = append(msgs, msg)
+	}
+	return fmt.Sprintf("could not load dependencies of %s:\n\t%s", e.ImportPath, strings.Join(msgs, "\n\t"))
+}
+
+// packageErrors returns the errors go list reported for pkg as a *DependencyError, or nil.
+func packageErrors(pkg *Package) error {
+	if pkg.Error == nil && len(pkg.DepsErrors) == 0 {
+		return nil
+	}
+	depErr := &DependencyError{ImportPath: pkg.ImportPath}
+	if pkg.Error != nil {
+		depErr.Errors = append(depErr.Errors, pkg.Error)
+	}
+	depErr.Errors = append(depErr.Errors, pkg.DepsErrors...)
+	return depErr
+}
+
+// missingPackageRegexp matches the import path in go list errors such as
+// "no required module provides package x" or "missing go.sum entry for module providing package x".
+var missingPackageRegexp = regexp.MustCompile(`providing package ([^\s;:()]+)|provides package ([^\s;:()]+)`)
+
+// missingImports returns the import paths which failed to load according to the DepsErrors of pkg.
+func missingImports(pkg *Package) []string {
+	seen := map[string]bool{}
+	var missing []string
+	for _, depErr := range pkg.DepsErrors {
+		importPath := ""
+		if match := missingPackageRegexp.FindStringSubmatch(depErr.Err); match != nil {
+			importPath = match[1] + match[2]
+		} else if len(depErr.ImportStack) > 0 {
+			importPath = depErr.ImportStack[len(depErr.ImportStack)-1]
+		}
+		if importPath != "" && !seen[importPath] {
+			seen[importPath] = true
+			missing = append(missing, importPath)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// goFlags returns the flags shared by every go list and go build invocation of the build.
+func (config *BuildConfig) goFlags() []string {
+	var flags []string
+	if config.ModMode != ModDefault {
+		flags = append(flags, "-mod="+string(config.ModMode))
+	}
+	if config.modFile != "" {
+		flags = append(flags, "-modfile="+config.modFile)
+	}
+	if config.overlay != nil {
+		flags = append(flags, "-overlay="+config.overlay.path)
+	}
+	return flags
+}
+
+func (config *BuildConfig) goMod(ctx context.Context, workDir string) (string, error) {
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	values, err := goEnv(cmdCtx, config.toolchain(), workDir, config.environ(), "GOMOD")
+	if err != nil {
+		return "", err
+	}
+	goMod := values["GOMOD"]
+	if goMod == "" || goMod == os.DevNull {
+		return "", fmt.Errorf("no go.mod found for %s, dependencies can only be resolved in module mode", workDir)
+	}
+	return goMod, nil
+}
+
+func (config *BuildConfig) requirements(ctx context.Context, workDir, modFile string) (map[string]string, error) {
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	output, err := config.toolchain().Mod(cmdCtx, &Invocation{Dir: workDir, Env: config.environ(), Args: []string{"edit", "-json", modFile}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read requirements of %s: %w", modFile, err)
+	}
+	requires := map[string]string{}
+	if len(output) == 0 {
+		return requires, nil
+	}
+	goModJSON := struct {
+		Require []ModuleVersion
+	}{}
+	if err = json.Unmarshal(output, &goModJSON); err != nil {
+		return nil, fmt.Errorf("failed to decode response of 'go mod edit -json %s': %w", modFile, err)
+	}
+	for _, require := range goModJSON.Require {
+		requires[require.Path] = require.Version
+	}
+	return requires, nil
+}
+
+// copyModFile copies go.mod and go.sum of the main module below TargetDir, the copy is passed
+// to the go command with -modfile so that resolving dependencies never rewrites user files.
+func (config *BuildConfig) copyModFile(goMod string) (string, error) {
+	sum := sha256.Sum256([]byte(goMod))
+	dir := filepath.Join(config.TargetDir, ".modfile", hex.EncodeToString(sum[:8]))
+	modFile := filepath.Join(dir, "go.mod")
+	if err := copyFile(modFile, goMod); err != nil {
+		return "", fmt.Errorf("could not copy %s: %w", goMod, err)
+	}
+	goSum := strings.TrimSuffix(goMod, ".mod") + ".sum"
+	if err := copyFile(strings.TrimSuffix(modFile, ".mod")+".sum", goSum); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("could not copy %s: %w", goSum, err)
+	}
+	return modFile, nil
+}
+
+func resolveDependencies(ctx context.Context, config *BuildConfig, workDir string, pkg *Package) error {
+	if config.ResolveMode == ResolveStrict {
+		return packageErrors(pkg)
+	}
+	if err := config.checkResolvable(pkg); err != nil {
+		return err
+	}
+
+	goMod, err := config.goMod(ctx, workDir)
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, packageErrors(pkg))
+	}
+	report := &ResolveReport{GoMod: goMod, ModFile: goMod, Missing: missingImports(pkg)}
+	if config.ResolveMode == ResolveModfile {
+		if report.ModFile, err = config.copyModFile(goMod); err != nil {
+			return err
+		}
+	}
+	before, err := config.requirements(ctx, workDir, report.ModFile)
+	if err != nil {
+		return err
+	}
+
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	var args []string
+	if config.ResolveMode == ResolveModfile {
+		args = append(args, "-modfile="+report.ModFile)
+	} else if _, err = config.toolchain().Mod(cmdCtx, &Invocation{Dir: workDir, Env: config.environ(), Args: []string{"download"}}); err != nil {
+		return fmt.Errorf("failed to go mod download: %w", err)
+	}
+	if len(report.Missing) > 0 {
+		args = append(args, report.Missing...)
+		if _, err = config.toolchain().Get(cmdCtx, &Invocation{Dir: workDir, Env: config.environ(), Args: args}); err != nil {
+			return fmt.Errorf("failed to go get %s: %w", strings.Join(report.Missing, " "), err)
+		}
+	}
+	if config.ResolveMode == ResolveModfile {
+		config.modFile = report.ModFile
+	}
+
+	after, err := config.requirements(ctx, workDir, report.ModFile)
+	if err != nil {
+		return err
+	}
+	for path, version := range after {
+		if oldVersion, ok := before[path]; !ok {
+			report.Added = append(report.Added, ModuleVersion{Path: path, Version: version})
+		} else if oldVersion != version {
+			report.Updated = append(report.Updated, ModuleVersion{Path: path, Version: version})
+		}
+	}
+	sort.Slice(report.Added, func(i, j int) bool { return report.Added[i].Path < report.Added[j].Path })
+	sort.Slice(report.Updated, func(i, j int) bool { return report.Updated[i].Path < report.Updated[j].Path })
+	config.resolve = report
+	return nil
+}
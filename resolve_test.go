@@ -0,0 +1,78 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// resolveToolchain adds example.com/dep to the requirements once go get has run.
+type resolveToolchain struct {
+	*FakeToolchain
+	getArgs []string
+	mods    [][]string
+}
+
+func (t *resolveToolchain) Mod(ctx context.Context, inv *Invocation) ([]byte, error) {
+	t.mods = append(t.mods, inv.Args)
+	if t.getArgs == nil {
+		return []byte(`{}`), nil
+	}
+	return []byte(`{"Require":[{"Path":"example.com/dep","Version":"v1.0.0"}]}`), nil
+}
+
+func (t *resolveToolchain) Get(ctx context.Context, inv *Invocation) ([]byte, error) {
+	t.getArgs = inv.Args
+	return nil, nil
+}
+
+func TestResolveDependencies(t *testing.T) {
+	const goMod = "module example.com/m\n"
+	newPkg := func() *Package {
+		return &Package{ImportPath: "example.com/m", DepsErrors: []*PackageError{
+			{Err: "no required module provides package example.com/dep; to add it:\n\tgo get example.com/dep"},
+		}}
+	}
+	setup := func(mode ResolveMode) (*BuildConfig, *resolveToolchain, string) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"go.mod": goMod})
+		toolchain := &resolveToolchain{FakeToolchain: NewFakeToolchain()}
+		toolchain.EnvValues["GOMOD"] = filepath.Join(dir, "go.mod")
+		config := &BuildConfig{WorkDir: dir, TargetDir: filepath.Join(dir, "target"), ResolveMode: mode, Toolchain: toolchain}
+		return config, toolchain, dir
+	}
+
+	config, toolchain, dir := setup(ResolveMode(0))
+	if err := resolveDependencies(context.Background(), config, dir, newPkg()); err != nil {
+		t.Fatal(err)
+	}
+	report := config.resolve
+	if report.ModFile == report.GoMod || !strings.HasPrefix(report.ModFile, config.TargetDir) {
+		t.Errorf("default mode resolved in %s, want a copy of %s below TargetDir", report.ModFile, report.GoMod)
+	}
+	if want := []string{"-modfile=" + report.ModFile, "example.com/dep"}; !reflect.DeepEqual(toolchain.getArgs, want) {
+		t.Errorf("go get arguments = %v, want %v", toolchain.getArgs, want)
+	}
+	for _, args := range toolchain.mods {
+		if args[0] != "edit" {
+			t.Errorf("default mode ran go mod %s", strings.Join(args, " "))
+		}
+	}
+	if want := []ModuleVersion{{Path: "example.com/dep", Version: "v1.0.0"}}; !reflect.DeepEqual(report.Added, want) {
+		t.Errorf("Added = %v, want %v", report.Added, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "go.mod")); string(data) != goMod {
+		t.Errorf("go.mod was rewritten to %q", data)
+	}
+
+	config, toolchain, dir = setup(ResolveStrict)
+	err := resolveDependencies(context.Background(), config, dir, newPkg())
+	var depErr *DependencyError
+	if !errors.As(err, &depErr) || toolchain.getArgs != nil {
+		t.Errorf("strict mode: err = %v, go get arguments = %v, want a *DependencyError without go get", err, toolchain.getArgs)
+	}
+}
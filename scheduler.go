@@ -0,0 +1,112 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+func (config *BuildConfig) concurrency() int {
+	if config.Concurrency > 0 {
+		return config.Concurrency
+	}
+	return runtime.NumCPU()
+}
+
+// depBuildSlots bounds the builds started by BuildDepPackage and its variants across the
+// process, its size is the concurrency of the first config built.
+var depBuildSlots struct {
+	once sync.Once
+	sem  chan struct{}
+}
+
+func acquireDepBuildSlot(ctx context.Context, config *BuildConfig) (release func(), err error) {
+	depBuildSlots.once.Do(func() {
+		depBuildSlots.sem = make(chan struct{}, config.concurrency())
+	})
+	select {
+	case depBuildSlots.sem <- struct{}{}:
+		return func() { <-depBuildSlots.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type buildTask struct {
+	importPath string
+	deps       []string // import paths of the tasks which must be built first
+	build      func(ctx context.Context) error
+}
+
+// runBuildTasks runs tasks with at most concurrency builds at the same time, a task starts only
+// after all of its dependencies have been built. A task whose dependency failed is not started.
+// Every failure is returned joined; with failFast the first failure cancels all outstanding tasks.
+func runBuildTasks(ctx context.Context, tasks []*buildTask, concurrency int, failFast bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(map[string]chan struct{}, len(tasks))
+	failed := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		done[task.importPath] = make(chan struct{})
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for _, task := range tasks {
+		task := task
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[task.importPath])
+
+			for _, dep := range task.deps {
+				if ch, ok := done[dep]; ok {
+					<-ch
+				}
+			}
+			mu.Lock()
+			for _, dep := range task.deps {
+				if failed[dep] {
+					failed[task.importPath] = true
+				}
+			}
+			skip := failed[task.importPath]
+			mu.Unlock()
+			if skip || ctx.Err() != nil {
+				return
+			}
+
+			sem <- struct{}{}
+			err := ctx.Err()
+			if err == nil {
+				err = task.build(ctx)
+			}
+			<-sem
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			failed[task.importPath] = true
+			if errors.Is(err, context.Canceled) && ctx.Err() != nil && len(errs) > 0 {
+				// canceled by an earlier failure in fail fast mode
+				return
+			}
+			errs = append(errs, fmt.Errorf("failed to build %s: %w", task.importPath, err))
+			if failFast {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) == 0 && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errors.Join(errs...)
+}
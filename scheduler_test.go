@@ -0,0 +1,99 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunBuildTasks(t *testing.T) {
+	errBuild := errors.New("build failed")
+	tests := []struct {
+		name      string
+		deps      map[string][]string // task and its dependencies, tasks are created in sorted order
+		fail      map[string]bool
+		want      []string // tasks built, dependencies before their importers
+		unordered bool     // the tasks of want are independent and built in any order
+		wantErrs  []string
+	}{
+		{name: "chain", deps: map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}}, want: []string{"a", "b", "c"}},
+		{name: "external deps", deps: map[string][]string{"a": {"fmt"}, "b": {"a", "unsafe"}}, want: []string{"a", "b"}},
+		{name: "failed dependency", deps: map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}}, fail: map[string]bool{"a": true},
+			want: []string{"a"}, wantErrs: []string{"failed to build a"}},
+		{name: "every failure", deps: map[string][]string{"a": nil, "b": nil, "c": {"a", "b"}}, fail: map[string]bool{"a": true, "b": true},
+			want: []string{"a", "b"}, unordered: true, wantErrs: []string{"failed to build a", "failed to build b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var built []string
+			var tasks []*buildTask
+			for _, name := range []string{"a", "b", "c"} {
+				deps, ok := tt.deps[name]
+				if !ok {
+					continue
+				}
+				name := name
+				tasks = append(tasks, &buildTask{importPath: name, deps: deps, build: func(ctx context.Context) error {
+					mu.Lock()
+					built = append(built, name)
+					mu.Unlock()
+					if tt.fail[name] {
+						return errBuild
+					}
+					return nil
+				}})
+			}
+			err := runBuildTasks(context.Background(), tasks, 2, false)
+			if len(tt.wantErrs) == 0 && err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.wantErrs {
+				if err == nil || !strings.Contains(err.Error(), want) || !errors.Is(err, errBuild) {
+					t.Errorf("runBuildTasks() error = %v, want %q", err, want)
+				}
+			}
+			if tt.unordered {
+				sort.Strings(built)
+			}
+			if !reflect.DeepEqual(built, tt.want) {
+				t.Errorf("built %v, want %v", built, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBuildTasksFailFast(t *testing.T) {
+	started := make(chan struct{})
+	canceled := false
+	tasks := []*buildTask{
+		{importPath: "slow", build: func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			canceled = true
+			return ctx.Err()
+		}},
+		{importPath: "fail", build: func(ctx context.Context) error {
+			<-started
+			return errors.New("build failed")
+		}},
+		{importPath: "next", deps: []string{"slow"}, build: func(ctx context.Context) error {
+			t.Error("task depending on a canceled task was built")
+			return nil
+		}},
+	}
+	err := runBuildTasks(context.Background(), tasks, 2, true)
+	if err == nil || !strings.Contains(err.Error(), "failed to build fail") {
+		t.Fatalf("runBuildTasks() error = %v, want the failure of fail", err)
+	}
+	if strings.Contains(err.Error(), "slow") {
+		t.Errorf("runBuildTasks() error = %v, the canceled task must not be reported", err)
+	}
+	if !canceled {
+		t.Error("outstanding task was not canceled")
+	}
+}
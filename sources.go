@@ -0,0 +1,195 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type overlay struct {
+	path    string            // overlay file passed to the go command with -overlay
+	dir     string            // directory holding the overlay file and the replacement files
+	replace map[string]string // absolute virtual path to the file holding its content
+}
+
+// sourcePath returns the file holding the content of path, which is a replacement file of the
+// overlay for generated sources.
+func (config *BuildConfig) sourcePath(path string) string {
+	if config.overlay != nil {
+		if replacement, ok := config.overlay.replace[path]; ok {
+			return replacement
+		}
+	}
+	return path
+}
+
+// restorePaths reports diagnostics in replacement files at their virtual path.
+func (ovl *overlay) restorePaths(buildErr *BuildError) {
+	for virtual, replacement := range ovl.replace {
+		for i := range buildErr.Diagnostics {
+			if buildErr.Diagnostics[i].File == replacement {
+				buildErr.Diagnostics[i].File = virtual
+			}
+		}
+	}
+}
+
+func sourcesHash(files map[string][]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %d\n", name, len(files[name]))
+		h.Write(files[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (config *BuildConfig) newOverlay(hash string) *overlay {
+	return &overlay{dir: filepath.Join(config.TargetDir, ".overlay", hash[:16]), replace: map[string]string{}}
+}
+
+// add stores files below the overlay directory and maps them into the directory dir, the go
+// command reads them as if they were in dir which is never modified.
+func (ovl *overlay) add(dir string, files map[string][]byte) error {
+	for name, data := range files {
+		clean := path.Clean(filepath.ToSlash(name))
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("source file name %s is not relative to the package directory", name)
+		}
+		replacement := filepath.Join(ovl.dir, "files", filepath.FromSlash(clean))
+		if err := os.MkdirAll(filepath.Dir(replacement), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(replacement, data, 0644); err != nil {
+			return fmt.Errorf("could not write source file %s: %w", replacement, err)
+		}
+		ovl.replace[filepath.Join(dir, filepath.FromSlash(clean))] = replacement
+	}
+	data, err := json.Marshal(struct{ Replace map[string]string }{ovl.replace})
+	if err != nil {
+		return err
+	}
+	ovl.path = filepath.Join(ovl.dir, "overlay.json")
+	if err = os.WriteFile(ovl.path, data, 0644); err != nil {
+		return fmt.Errorf("could not write overlay file %s: %w", ovl.path, err)
+	}
+	return nil
+}
+
+// removeOverlay drops the overlay once the root package is built, dependencies never use it.
+func (config *BuildConfig) removeOverlay() {
+	if config.overlay == nil {
+		return
+	}
+	if !config.KeepWorkDir {
+		os.RemoveAll(config.overlay.dir)
+	}
+	config.overlay = nil
+}
+
+func BuildSources(config *BuildConfig, files map[string][]byte) (*BuildResult, error) {
+	return BuildSourcesContext(context.Background(), config, files)
+}
+
+// BuildSourcesContext builds a package from in-memory sources keyed by their file name relative to
+// the package directory, together with its dependencies. The sources are passed to the go command
+// with -overlay and appear in a virtual directory below WorkDir, nothing is written outside TargetDir.
+// PkgPath defaults to the name of that directory, _goloader_sources_ followed by a hash of the sources.
+func BuildSourcesContext(ctx context.Context, config *BuildConfig, files map[string][]byte) (*BuildResult, error) {
+	pkg, cached, err := buildSources(ctx, config, files)
+	if err != nil {
+		return nil, err
+	}
+	return buildResult(ctx, config, pkg, cached)
+}
+
+func BuildFS(config *BuildConfig, fsys fs.FS) (*BuildResult, error) {
+	return BuildFSContext(context.Background(), config, fsys)
+}
+
+// BuildFSContext builds the package whose sources are at the root of fsys, see BuildSourcesContext.
+func BuildFSContext(ctx context.Context, config *BuildConfig, fsys fs.FS) (*BuildResult, error) {
+	files := map[string][]byte{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read sources: %w", err)
+	}
+	return BuildSourcesContext(ctx, config, files)
+}
+
+func buildSources(ctx context.Context, config *BuildConfig, files map[string][]byte) (*Package, bool, error) {
+	config.overlay = nil
+	defer config.removeOverlay()
+	if config.WorkDir == `` {
+		config.WorkDir = "."
+	}
+	workDir, err := filepath.Abs(config.WorkDir)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get absolute path at %s: %w", config.WorkDir, err)
+	}
+	hash := sourcesHash(files)
+	dir := filepath.Join(workDir, "_goloader_sources_"+hash[:16])
+	if _, err = os.Stat(dir); err == nil {
+		return nil, false, fmt.Errorf("virtual source directory %s already exists", dir)
+	}
+
+	config.BuildPaths = nil
+	for name := range files {
+		if !strings.Contains(name, "/") && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			config.BuildPaths = append(config.BuildPaths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(config.BuildPaths)
+	if err = initConfig(ctx, config, false); err != nil {
+		return nil, false, err
+	}
+
+	config.overlay = config.newOverlay(hash)
+	if err = config.overlay.add(dir, files); err != nil {
+		return nil, false, err
+	}
+	pkg, graph, err := getPkg(ctx, config, config.WorkDir, config.BuildPaths...)
+	if err != nil {
+		return nil, false, err
+	}
+	if pkg.Name == "main" {
+		if pkg, graph, err = prepareMain(ctx, config, pkg); err != nil {
+			return nil, false, err
+		}
+	}
+	// the package name alone would collide between unrelated sources, the virtual directory is unique to them
+	if config.PkgPath == "" {
+		config.PkgPath = filepath.Base(dir)
+	}
+	if err = config.setTarget(config.importPathOf(pkg)); err != nil {
+		return nil, false, err
+	}
+
+	cached, err := buildPkg(ctx, config, pkg, graph)
+	if err != nil {
+		return nil, false, err
+	}
+	return pkg, cached, nil
+}
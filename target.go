@@ -0,0 +1,136 @@
+package goloaderbuilder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// goPathKeys are the go env values used to locate package sources.
+var goPathKeys = []string{"GOPATH", "GOMODCACHE", "GOROOT"}
+
+// relativeImportPath returns the import path of dir when it lies under root.
+func relativeImportPath(root, dir string) (string, bool) {
+	if root == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// unescapeModulePath reverses the case encoding of module cache paths, "!a" stands for "A".
+func unescapeModulePath(path string) string {
+	var b strings.Builder
+	bang := false
+	for _, r := range path {
+		if bang {
+			b.WriteRune(unicode.ToUpper(r))
+			bang = false
+		} else if r == '!' {
+			bang = true
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// importPathOf returns the import path used to lay out the archive of pkg. Packages named on
+// the command line by their files are reported as command-line-arguments by go list, their
+// path is PkgPath if set, or derived from the GOROOT, GOPATH or module cache directory holding them.
+// Main packages are laid out at the unique PkgPath given by prepareMain.
+func (config *BuildConfig) importPathOf(pkg *Package) string {
+	if pkg.ImportPath != "command-line-arguments" && config.entry == "" {
+		return pkg.ImportPath
+	}
+	if config.PkgPath != "" {
+		return config.PkgPath
+	}
+	if path := config.dirImportPath(pkg); path != "" {
+		return path
+	}
+	return filepath.Base(strings.TrimSuffix(config.BuildPaths[0], ".go"))
+}
+
+// dirImportPath derives the import path of pkg from the directory holding its sources, or
+// returns "" when the directory is not in a module, GOROOT, GOPATH or the module cache.
+func (config *BuildConfig) dirImportPath(pkg *Package) string {
+	if pkg.Module != nil && pkg.Module.Dir != "" {
+		if pkg.Dir == pkg.Module.Dir {
+			return pkg.Module.Path
+		}
+		if path, ok := relativeImportPath(pkg.Module.Dir, pkg.Dir); ok {
+			return pkg.Module.Path + "/" + path
+		}
+	}
+	if path, ok := relativeImportPath(filepath.Join(config.goEnv["GOROOT"], "src"), pkg.Dir); ok {
+		return path
+	}
+	for _, goPath := range filepath.SplitList(config.goEnv["GOPATH"]) {
+		if path, ok := relativeImportPath(filepath.Join(goPath, "src"), pkg.Dir); ok {
+			return path
+		}
+	}
+	if path, ok := relativeImportPath(config.goEnv["GOMODCACHE"], pkg.Dir); ok {
+		elems := strings.Split(path, "/")
+		for i, elem := range elems {
+			if at := strings.LastIndex(elem, "@"); at >= 0 {
+				elems[i] = elem[:at]
+			}
+		}
+		return unescapeModulePath(strings.Join(elems, "/"))
+	}
+	return ""
+}
+
+// archivePath maps an import path to its archive under the platform directory, every path
+// element becomes a directory so that packages sharing a base name never collide.
+func (config *BuildConfig) archivePath(importPath string) string {
+	return filepath.Join(config.PlatformDir(), filepath.FromSlash(importPath)) + ".a"
+}
+
+func (config *BuildConfig) setTarget(importPath string) error {
+	if config.PkgPath == "" {
+		config.PkgPath = importPath
+	}
+	config.TargetPath = config.archivePath(importPath)
+	if err := os.MkdirAll(filepath.Dir(config.TargetPath), os.ModePerm); err != nil {
+		return fmt.Errorf("could not create target dir at %s: %w", filepath.Dir(config.TargetPath), err)
+	}
+	return nil
+}
+
+func Clean(config *BuildConfig) ([]string, error) {
+	return CleanContext(context.Background(), config)
+}
+
+// CleanContext removes the archives of the target platform from TargetDir together with the build
+// cache and the generated sources of the builder, and returns the removed paths. Outputs of other
+// platforms are kept.
+func CleanContext(ctx context.Context, config *BuildConfig) ([]string, error) {
+	path, err := filepath.Abs(config.TargetDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path at %s: %w", config.TargetDir, err)
+	}
+	config.TargetDir = path
+	if _, err = config.loadGoEnv(ctx); err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, dir := range []string{config.PlatformDir(), config.cacheDir(), filepath.Join(config.TargetDir, ".overlay")} {
+		if _, err = os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return removed, fmt.Errorf("could not remove %s: %w", dir, err)
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
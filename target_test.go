@@ -0,0 +1,46 @@
+package goloaderbuilder
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUnescapeModulePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"example.com/p", "example.com/p"},
+		{"github.com/!burnt!sushi/toml@v1.3.2", "github.com/BurntSushi/toml@v1.3.2"},
+		{"!a!b", "AB"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := unescapeModulePath(tt.path); got != tt.want {
+			t.Errorf("unescapeModulePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestArchivePath(t *testing.T) {
+	target := filepath.Join("root", "target")
+	tests := []struct {
+		config     *BuildConfig
+		importPath string
+		want       string
+	}{
+		{&BuildConfig{TargetDir: target, GOOS: "linux", GOARCH: "arm64"}, "example.com/p",
+			filepath.Join(target, "linux_arm64", "example.com", "p.a")},
+		{&BuildConfig{TargetDir: target, GOOS: "linux", GOARCH: "amd64", Microarch: "v3"}, "fmt",
+			filepath.Join(target, "linux_amd64_v3", "fmt.a")},
+		{&BuildConfig{TargetDir: target, goEnv: map[string]string{"GOOS": "darwin", "GOARCH": "arm64", "GOARM64": "v8.0"}}, "example.com/p/internal/x",
+			filepath.Join(target, "darwin_arm64_v8.0", "example.com", "p", "internal", "x.a")},
+		{&BuildConfig{TargetDir: target, GOOS: "windows", GOARCH: "amd64"}, "example.com/p.test/example.com/p_test",
+			filepath.Join(target, "windows_amd64", "example.com", "p.test", "example.com", "p_test.a")},
+	}
+	for _, tt := range tests {
+		if got := tt.config.archivePath(tt.importPath); got != tt.want {
+			t.Errorf("archivePath(%q) = %q, want %q", tt.importPath, got, tt.want)
+		}
+	}
+}
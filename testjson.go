@@ -0,0 +1,152 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestEvent is an event of the go test -json output, see go doc test2json.
+type TestEvent struct {
+	Time    time.Time `json:",omitempty"`
+	Action  string
+	Package string  `json:",omitempty"`
+	Test    string  `json:",omitempty"`
+	Elapsed float64 `json:",omitempty"`
+	Output  string  `json:",omitempty"`
+}
+
+// TestEventWriter converts the output of a test main run with -test.v=test2json into test events,
+// as go test -json does for the output of a test binary.
+type TestEventWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	pkg     string
+	start   time.Time
+	test    string
+	partial []byte
+	err     error
+}
+
+// testFrameMarker starts the framing lines of the test output with -test.v=test2json.
+const testFrameMarker = '\x16'
+
+var testResultActions = map[string]string{"--- PASS: ": "pass", "--- FAIL: ": "fail", "--- SKIP: ": "skip", "--- BENCH: ": "bench"}
+
+func NewTestEventWriter(w io.Writer, pkg string) *TestEventWriter {
+	t := &TestEventWriter{encoder: json.NewEncoder(w), pkg: pkg, start: time.Now()}
+	t.emit(&TestEvent{Action: "start"})
+	return t
+}
+
+func (t *TestEventWriter) emit(event *TestEvent) {
+	if t.err != nil {
+		return
+	}
+	event.Time = time.Now()
+	event.Package = t.pkg
+	t.err = t.encoder.Encode(event)
+}
+
+func (t *TestEventWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		end := bytes.IndexByte(t.partial, '\n')
+		if end < 0 {
+			break
+		}
+		t.line(string(t.partial[:end+1]))
+		t.partial = t.partial[end+1:]
+	}
+	return len(p), t.err
+}
+
+func (t *TestEventWriter) line(line string) {
+	framed := strings.HasPrefix(line, string(testFrameMarker))
+	line = strings.TrimPrefix(line, string(testFrameMarker))
+	if !framed {
+		t.emit(&TestEvent{Action: "output", Test: t.test, Output: line})
+		return
+	}
+	text := strings.TrimSpace(line)
+	for _, frame := range []struct{ prefix, action string }{{"=== RUN   ", "run"}, {"=== PAUSE ", "pause"}, {"=== CONT  ", "cont"}, {"=== NAME  ", ""}} {
+		if strings.HasPrefix(line, frame.prefix) {
+			t.test = strings.TrimSpace(strings.TrimPrefix(line, frame.prefix))
+			if frame.action != "" {
+				t.emit(&TestEvent{Action: frame.action, Test: t.test})
+				t.emit(&TestEvent{Action: "output", Test: t.test, Output: line})
+			}
+			return
+		}
+	}
+	for prefix, action := range testResultActions {
+		if strings.HasPrefix(text, prefix) {
+			// "--- PASS: TestName (0.01s)"
+			name, elapsed := strings.TrimPrefix(text, prefix), 0.0
+			if open := strings.LastIndex(name, " ("); open >= 0 && strings.HasSuffix(name, "s)") {
+				elapsed, _ = strconv.ParseFloat(name[open+2:len(name)-2], 64)
+				name = name[:open]
+			}
+			t.test = name
+			t.emit(&TestEvent{Action: "output", Test: name, Output: line})
+			t.emit(&TestEvent{Action: action, Test: name, Elapsed: elapsed})
+			return
+		}
+	}
+	// final PASS, FAIL and summary lines belong to the package
+	t.test = ""
+	t.emit(&TestEvent{Action: "output", Output: line})
+}
+
+// Close flushes a partial output line and reports the package result for the exit code of the test main.
+func (t *TestEventWriter) Close(exitCode int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.partial) > 0 {
+		t.line(string(t.partial) + "\n")
+		t.partial = nil
+	}
+	action := "pass"
+	if exitCode != 0 {
+		action = "fail"
+	}
+	t.emit(&TestEvent{Action: action, Elapsed: time.Since(t.start).Seconds()})
+	return t.err
+}
+
+// RunTestJSON runs a loaded test main and writes its results to w in the go test -json format. The
+// output of the tests is captured by replacing os.Stdout and os.Stderr while run executes, run is
+// given the test flags args preceded by -test.v=test2json and returns the exit code of the tests.
+func RunTestJSON(w io.Writer, pkg string, args []string, run func(args []string) int) (int, error) {
+	events := NewTestEventWriter(w, pkg)
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = pw, pw
+	done := make(chan struct{})
+	go func() {
+		io.Copy(events, r)
+		close(done)
+	}()
+
+	exitCode := 1
+	func() {
+		defer func() {
+			os.Stdout, os.Stderr = stdout, stderr
+			pw.Close()
+			<-done
+			r.Close()
+		}()
+		exitCode = run(append([]string{"-test.v=test2json"}, args...))
+	}()
+	return exitCode, events.Close(exitCode)
+}
@@ -0,0 +1,90 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTestEventWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		exitCode int
+		want     []TestEvent
+	}{
+		{
+			name:   "pass",
+			output: "\x16=== RUN   TestA\nhello\n\x16--- PASS: TestA (0.25s)\n\x16PASS\n",
+			want: []TestEvent{
+				{Action: "start"},
+				{Action: "run", Test: "TestA"},
+				{Action: "output", Test: "TestA", Output: "=== RUN   TestA\n"},
+				{Action: "output", Test: "TestA", Output: "hello\n"},
+				{Action: "output", Test: "TestA", Output: "--- PASS: TestA (0.25s)\n"},
+				{Action: "pass", Test: "TestA", Elapsed: 0.25},
+				{Action: "output", Output: "PASS\n"},
+				{Action: "pass"},
+			},
+		},
+		{
+			name:     "fail",
+			output:   "\x16=== RUN   TestB\n\x16=== PAUSE TestB\n\x16=== CONT  TestB\n\x16=== NAME  TestB\n    b_test.go:3: wrong\n\x16--- FAIL: TestB (0.00s)\n\x16FAIL",
+			exitCode: 1,
+			want: []TestEvent{
+				{Action: "start"},
+				{Action: "run", Test: "TestB"},
+				{Action: "output", Test: "TestB", Output: "=== RUN   TestB\n"},
+				{Action: "pause", Test: "TestB"},
+				{Action: "output", Test: "TestB", Output: "=== PAUSE TestB\n"},
+				{Action: "cont", Test: "TestB"},
+				{Action: "output", Test: "TestB", Output: "=== CONT  TestB\n"},
+				{Action: "output", Test: "TestB", Output: "    b_test.go:3: wrong\n"},
+				{Action: "output", Test: "TestB", Output: "--- FAIL: TestB (0.00s)\n"},
+				{Action: "fail", Test: "TestB"},
+				{Action: "output", Output: "FAIL\n"},
+				{Action: "fail"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			w := NewTestEventWriter(out, "example.com/p")
+			// lines are split across writes
+			for i := 0; i < len(tt.output); i += 7 {
+				end := i + 7
+				if end > len(tt.output) {
+					end = len(tt.output)
+				}
+				if _, err := w.Write([]byte(tt.output[i:end])); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(tt.exitCode); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []TestEvent
+			decoder := json.NewDecoder(out)
+			for decoder.More() {
+				event := TestEvent{}
+				if err := decoder.Decode(&event); err != nil {
+					t.Fatal(err)
+				}
+				if event.Time.IsZero() || event.Package != "example.com/p" {
+					t.Errorf("event %+v has no time or package", event)
+				}
+				if event.Test == "" && (event.Action == "pass" || event.Action == "fail") {
+					// the elapsed time of the package is measured
+					event.Elapsed = 0
+				}
+				got = append(got, TestEvent{Action: event.Action, Test: event.Test, Elapsed: event.Elapsed, Output: event.Output})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
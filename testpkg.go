@@ -0,0 +1,700 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TestMainEntry is the exported function of the generated test main, it runs the tests with
+// the test flags it is given, as a test binary would, and returns the exit code.
+const TestMainEntry = "GoloaderTestMain"
+
+// testMainFile is added to the external test package, which may import the package under test.
+const testMainFile = "zz_goloader_testmain_test.go"
+
+// testGoVersions are the go releases whose testing package the generated test main is known to work with.
+var testGoVersions = []string{"go1.20", "go1.21", "go1.22", "go1.23", "go1.24", "go1.25", "go1.26", "go1.27"}
+
+var goReleaseRegexp = regexp.MustCompile(`^go1\.[0-9]+`)
+
+// checkTestGoVersion fails unless goVersion, as reported by go env GOVERSION, is one of testGoVersions.
+func checkTestGoVersion(goVersion string) error {
+	release := goReleaseRegexp.FindString(goVersion)
+	for _, supported := range testGoVersions {
+		if release == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("BuildTestPackage does not support the go toolchain %s, supported go releases are %s",
+		goVersion, strings.Join(testGoVersions, ", "))
+}
+
+func BuildTestPackage(config *BuildConfig) (*BuildResult, error) {
+	return BuildTestPackageContext(context.Background(), config)
+}
+
+// BuildTestPackageContext builds the internal and external test packages of the package directory
+// in BuildPaths together with a generated test main, and the dependencies of both. The result root
+// is the external test package with PkgPath "<import path>_test" and Entry TestMainEntry, which has
+// the type func(args []string) int. Test variants of packages are written below PlatformDir in
+// "<import path>.test" and listed in DepFiles after the other dependencies.
+func BuildTestPackageContext(ctx context.Context, config *BuildConfig) (*BuildResult, error) {
+	if !config.KeepWorkDir {
+		defer os.RemoveAll(config.WorkDir)
+	}
+	config.overlay = nil
+	defer config.removeOverlay()
+	if err := initConfig(ctx, config, true); err != nil {
+		return nil, err
+	}
+	if len(config.BuildPaths) != 1 {
+		return nil, fmt.Errorf("invalid source package path")
+	}
+	if err := checkTestGoVersion(config.goEnv["GOVERSION"]); err != nil {
+		return nil, err
+	}
+	absPath := config.BuildPaths[0]
+	fileInfo, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat path at %s: %w", absPath, err)
+	}
+	if !fileInfo.IsDir() {
+		return nil, fmt.Errorf("path at %s is not a directory", absPath)
+	}
+
+	pkg, _, err := getPkg(ctx, config, config.WorkDir, absPath)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Name == "main" {
+		return nil, fmt.Errorf("tests of main package %s can not be loaded, its symbols would collide with the host main package", pkg.ImportPath)
+	}
+	if len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) == 0 {
+		return nil, fmt.Errorf("no test files found in %s", absPath)
+	}
+	src, err := config.generateTestMain(pkg)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate test main of %s: %w", pkg.ImportPath, err)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", pkg.Dir, src)
+	config.overlay = config.newOverlay(hex.EncodeToString(h.Sum(nil)))
+	if err = config.overlay.add(pkg.Dir, map[string][]byte{testMainFile: src}); err != nil {
+		return nil, err
+	}
+
+	testConfig := config.testVariantConfig()
+	variants, graph, order, err := listTestVariants(ctx, testConfig, pkg)
+	if err != nil {
+		return nil, err
+	}
+	testDir := pkg.ImportPath + ".test"
+	var root *builtArchive
+	var testArchives []*builtArchive
+	for _, variant := range variants {
+		pkgPath := strings.TrimSuffix(variant.ImportPath, " ["+testDir+"]")
+		archive := &builtArchive{config: testConfig, pkg: variant, pkgPath: pkgPath, path: config.archivePath(testDir + "/" + pkgPath)}
+		if err = copyFile(archive.path, variant.Export); err != nil {
+			return nil, fmt.Errorf("could not copy test archive of %s: %w", variant.ImportPath, err)
+		}
+		if pkgPath == pkg.ImportPath+"_test" {
+			root = archive
+		} else {
+			testArchives = append(testArchives, archive)
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("go list reported no external test package for %s", pkg.ImportPath)
+	}
+	config.PkgPath = root.pkgPath
+	config.TargetPath = root.path
+	config.entry = root.pkgPath + "." + TestMainEntry
+
+	// the dependencies shared with the host are built as usual from the union of the variant imports
+	depSet := map[string]bool{}
+	for _, variant := range variants {
+		for _, dep := range variant.Deps {
+			if graph[dep] != nil && graph[dep].ForTest == "" && dep != pkg.ImportPath {
+				depSet[dep] = true
+			}
+		}
+	}
+	testDeps := &Package{ImportPath: "command-line-arguments"}
+	for _, importPath := range order {
+		if depSet[importPath] {
+			testDeps.Deps = append(testDeps.Deps, importPath)
+		}
+	}
+	roots := []string{root.path}
+	for _, archive := range testArchives {
+		roots = append(roots, archive.path)
+	}
+	deps, err := buildDependencies(ctx, config, testDeps, roots)
+	if err != nil {
+		return nil, err
+	}
+	return writeResult(config, root, append(deps, testArchives...))
+}
+
+// testVariantConfig returns the config the test variants are compiled with.
+func (config *BuildConfig) testVariantConfig() *BuildConfig {
+	testConfig := *config
+	if config.dynlink() {
+		// the test variants are compiled as dependencies of the test binary, not as command line packages
+		testConfig.ExtraBuildFlags = append([]string{"-gcflags=all="}, config.ExtraBuildFlags...)
+	}
+	return &testConfig
+}
+
+// listTestVariants compiles the test variants of pkg with go list -export, which leaves their
+// archives in the build cache without linking a test binary.
+func listTestVariants(ctx context.Context, config *BuildConfig, pkg *Package) ([]*Package, map[string]*Package, []string, error) {
+	buildFlags, err := config.buildFlags()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	flags := []string{"-test", "-export"}
+	for _, buildFlag := range buildFlags {
+		switch flagName(buildFlag) {
+		case "n", "x", "v", "json", "work":
+		default:
+			flags = append(flags, buildFlag)
+		}
+	}
+	cmdCtx, cancel := config.commandContext(ctx)
+	defer cancel()
+	graph, order, err := goListDeps(cmdCtx, config.toolchain(), config.WorkDir, config.environ(), flags, pkg.Dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var variants []*Package
+	for _, importPath := range order {
+		variant := graph[importPath]
+		if err = packageErrors(variant); err != nil {
+			return nil, nil, nil, err
+		}
+		if variant.ForTest != pkg.ImportPath {
+			continue
+		}
+		if variant.Export == "" {
+			return nil, nil, nil, fmt.Errorf("go list reported no archive for %s", variant.ImportPath)
+		}
+		if err = config.checkCgo(variant); err != nil {
+			return nil, nil, nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, graph, order, nil
+}
+
+// testFunc is a test, benchmark, fuzz target or example of the test packages, qualified with the
+// alias of the internal test package when it is declared there.
+type testFunc struct {
+	name      string
+	qualified string
+	output    string
+	unordered bool
+}
+
+type testMain struct {
+	imports    map[string]string // import path to alias in the generated file
+	tests      []testFunc
+	benchmarks []testFunc
+	fuzzTarget []testFunc
+	examples   []testFunc
+	testMain   string
+	exitCode   bool // testing.M of GOROOT has the exitCode field read after TestMain returns
+}
+
+func importAlias(importPath string) string {
+	return "goloadertest_" + strings.NewReplacer("/", "_", ".", "_", "-", "_", "~", "_").Replace(importPath)
+}
+
+func (m *testMain) qualifier(importPath string) string {
+	alias := importAlias(importPath)
+	m.imports[importPath] = alias
+	return alias + "."
+}
+
+// isTestName reports whether name is prefix followed by nothing or by a character which is not
+// lower case, as the go command decides for TestXxx functions.
+func isTestName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// hasTestingParam reports whether fn takes a single *testing.<typeName> parameter.
+func hasTestingParam(file *ast.File, fn *ast.FuncDecl, typeName string) bool {
+	if fn.Recv != nil || fn.Type.TypeParams != nil || fn.Type.Results != nil || fn.Type.Params == nil || len(fn.Type.Params.List) != 1 {
+		return false
+	}
+	param := fn.Type.Params.List[0]
+	if len(param.Names) > 1 {
+		return false
+	}
+	star, ok := param.Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != typeName {
+		return false
+	}
+	pkgName, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == "testing" {
+			return spec.Name == nil && pkgName.Name == "testing" || spec.Name != nil && spec.Name.Name == pkgName.Name
+		}
+	}
+	return false
+}
+
+// addTestFiles collects the tests of files, which are referred to through an import of importPath
+// unless it is empty.
+func (m *testMain) addTestFiles(config *BuildConfig, dir string, files []string, importPath string) error {
+	qualify := func(name string) string {
+		if importPath == "" {
+			return name
+		}
+		return m.qualifier(importPath) + name
+	}
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for _, name := range files {
+		filename := filepath.Join(dir, name)
+		src, err := os.ReadFile(config.sourcePath(filename))
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, file)
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			name := fn.Name.Name
+			switch {
+			case name == "TestMain" && hasTestingParam(file, fn, "M"):
+				if m.testMain != "" {
+					return fmt.Errorf("%s: multiple definitions of TestMain", fset.Position(fn.Pos()))
+				}
+				m.testMain = qualify(name)
+			case isTestName(name, "Test") && hasTestingParam(file, fn, "T"):
+				m.tests = append(m.tests, testFunc{name: name, qualified: qualify(name)})
+			case isTestName(name, "Benchmark") && hasTestingParam(file, fn, "B"):
+				m.benchmarks = append(m.benchmarks, testFunc{name: name, qualified: qualify(name)})
+			case isTestName(name, "Fuzz") && hasTestingParam(file, fn, "F"):
+				m.fuzzTarget = append(m.fuzzTarget, testFunc{name: name, qualified: qualify(name)})
+			}
+		}
+	}
+	for _, example := range doc.Examples(parsed...) {
+		if example.Output == "" && !example.EmptyOutput {
+			// examples without output comment are only compiled
+			continue
+		}
+		name := "Example" + example.Name
+		m.examples = append(m.examples, testFunc{name: name, qualified: qualify(name),
+			output: example.Output, unordered: example.Unordered})
+	}
+	return nil
+}
+
+// generateTestMain returns the source of the test main added to the external test package of pkg.
+// testing.MainStart needs an implementation of the unexported testDeps interface, it is generated
+// from the testing package of the target GOROOT because its methods change between go releases.
+func (config *BuildConfig) generateTestMain(pkg *Package) ([]byte, error) {
+	m := &testMain{imports: map[string]string{}}
+	if err := m.addTestFiles(config, pkg.Dir, pkg.TestGoFiles, pkg.ImportPath); err != nil {
+		return nil, err
+	}
+	if err := m.addTestFiles(config, pkg.Dir, pkg.XTestGoFiles, ""); err != nil {
+		return nil, err
+	}
+	testing := m.qualifier("testing")
+	deps, mainStartParams, err := m.testDeps(config.goEnv["GOROOT"], pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &bytes.Buffer{}
+	writeTable := func(name, typeName string, funcs []testFunc, field string) {
+		fmt.Fprintf(body, "var %s = []%s%s{\n", name, testing, typeName)
+		for _, f := range funcs {
+			if typeName == "InternalExample" {
+				fmt.Fprintf(body, "\t{Name: %q, F: %s, Output: %q, Unordered: %t},\n", f.name, f.qualified, f.output, f.unordered)
+			} else {
+				fmt.Fprintf(body, "\t{Name: %q, %s: %s},\n", f.name, field, f.qualified)
+			}
+		}
+		fmt.Fprintf(body, "}\n\n")
+	}
+	writeTable("goloaderTestTests", "InternalTest", m.tests, "F")
+	writeTable("goloaderTestBenchmarks", "InternalBenchmark", m.benchmarks, "F")
+	if mainStartParams == 5 {
+		writeTable("goloaderTestFuzzTargets", "InternalFuzzTarget", m.fuzzTarget, "Fn")
+	}
+	writeTable("goloaderTestExamples", "InternalExample", m.examples, "")
+	body.WriteString(deps)
+
+	flag, osPkg := m.qualifier("flag"), m.qualifier("os")
+	fmt.Fprintf(body, "\n// %s runs the tests with the test flags args, as the test binary of %s would, and returns its exit code.\n", TestMainEntry, pkg.ImportPath)
+	fmt.Fprintf(body, "func %s(args []string) int {\n", TestMainEntry)
+	fmt.Fprintf(body, "\tcommandLine, osArgs := %sCommandLine, %sArgs\n", flag, osPkg)
+	fmt.Fprintf(body, "\tdefer func() { %sCommandLine, %sArgs = commandLine, osArgs }()\n", flag, osPkg)
+	fmt.Fprintf(body, "\t%sArgs = append([]string{%q}, args...)\n", osPkg, filepath.Base(pkg.ImportPath)+".test")
+	fmt.Fprintf(body, "\t%sCommandLine = %sNewFlagSet(%sArgs[0], %sContinueOnError)\n", flag, flag, osPkg, flag)
+	if mainStartParams == 5 {
+		fmt.Fprintf(body, "\tm := %sMainStart(goloaderTestDeps{}, goloaderTestTests, goloaderTestBenchmarks, goloaderTestFuzzTargets, goloaderTestExamples)\n", testing)
+	} else {
+		fmt.Fprintf(body, "\tm := %sMainStart(goloaderTestDeps{}, goloaderTestTests, goloaderTestBenchmarks, goloaderTestExamples)\n", testing)
+	}
+	fmt.Fprintf(body, "\tif %sCommandLine.Lookup(\"test.v\") == nil {\n", flag)
+	fmt.Fprintf(body, "\t\t%sFprintln(%sStderr, \"testing flags are registered by the first test run of a process, run the tests of %s in a new process\")\n", m.qualifier("fmt"), osPkg, pkg.ImportPath)
+	fmt.Fprintf(body, "\t\treturn 2\n\t}\n")
+	if m.testMain != "" {
+		if !m.exitCode {
+			return nil, fmt.Errorf("testing.M in %s has no integer exitCode field, TestMain of %s is not supported", filepath.Join(config.goEnv["GOROOT"], "src", "testing"), pkg.ImportPath)
+		}
+		reflect := m.qualifier("reflect")
+		fmt.Fprintf(body, "\t%s(m)\n", m.testMain)
+		fmt.Fprintf(body, "\treturn int(%sValueOf(m).Elem().FieldByName(\"exitCode\").Int())\n", reflect)
+	} else {
+		fmt.Fprintf(body, "\treturn m.Run()\n")
+	}
+	fmt.Fprintf(body, "}\n")
+
+	src := &bytes.Buffer{}
+	fmt.Fprintf(src, "// Code generated by goloaderbuilder. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg.Name+"_test")
+	importPaths := make([]string, 0, len(m.imports))
+	for importPath := range m.imports {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+	for _, importPath := range importPaths {
+		fmt.Fprintf(src, "\t%s %q\n", m.imports[importPath], importPath)
+	}
+	fmt.Fprintf(src, ")\n\n")
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// testDepsBodies implement the methods of testDeps the tests rely on, other methods return zero
+// values. Fuzzing runs in worker processes of the test binary and is not supported.
+var testDepsBodies = map[string]struct {
+	signature string
+	body      string
+	imports   []string
+}{
+	"MatchString":     {"(string, string) (bool, error)", "return {regexp}MatchString(p0, p1)", []string{"regexp"}},
+	"StartCPUProfile": {"({io}Writer) (error)", "return {runtime/pprof}StartCPUProfile(p0)", []string{"io", "runtime/pprof"}},
+	"StopCPUProfile":  {"() ()", "{runtime/pprof}StopCPUProfile()", []string{"runtime/pprof"}},
+	"WriteProfileTo": {"(string, {io}Writer, int) (error)",
+		"if profile := {runtime/pprof}Lookup(p0); profile != nil {\n\t\treturn profile.WriteTo(p1, p2)\n\t}\n\treturn nil",
+		[]string{"io", "runtime/pprof"}},
+}
+
+type testDepsGenerator struct {
+	m       *testMain
+	aliases map[string]ast.Expr            // type aliases of the testing package
+	imports map[ast.Expr]map[string]string // imports of the file declaring an alias
+}
+
+// testDeps generates the goloaderTestDeps type and returns it with the number of MainStart parameters.
+func (m *testMain) testDeps(goroot string, pkg *Package) (string, int, error) {
+	dir := filepath.Join(goroot, "src", "testing")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", 0, fmt.Errorf("could not read testing package of GOROOT: %w", err)
+	}
+	g := &testDepsGenerator{m: m, aliases: map[string]ast.Expr{}, imports: map[ast.Expr]map[string]string{}}
+	var iface *ast.InterfaceType
+	var ifaceImports map[string]string
+	mainStartParams := 0
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", 0, err
+		}
+		fileImports := map[string]string{}
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			local := path[strings.LastIndex(path, "/")+1:]
+			if spec.Name != nil {
+				local = spec.Name.Name
+			}
+			fileImports[local] = path
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil && decl.Name.Name == "MainStart" {
+					for _, param := range decl.Type.Params.List {
+						mainStartParams += len(param.Names)
+					}
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					typeSpec, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					if typeSpec.Assign.IsValid() {
+						g.aliases[typeSpec.Name.Name] = typeSpec.Type
+						g.imports[typeSpec.Type] = fileImports
+					}
+					if typeSpec.Name.Name == "testDeps" {
+						iface, _ = typeSpec.Type.(*ast.InterfaceType)
+						ifaceImports = fileImports
+					}
+					if structType, ok := typeSpec.Type.(*ast.StructType); ok && typeSpec.Name.Name == "M" {
+						m.exitCode = hasIntField(structType, "exitCode")
+					}
+				}
+			}
+		}
+	}
+	if iface == nil || mainStartParams == 0 {
+		return "", 0, fmt.Errorf("testing package in %s has no testDeps interface or MainStart function", dir)
+	}
+	if mainStartParams != 4 && mainStartParams != 5 {
+		return "", 0, fmt.Errorf("testing.MainStart in %s takes %d parameters, only 4 or 5 are supported", dir, mainStartParams)
+	}
+
+	modulePath := ""
+	if pkg.Module != nil {
+		modulePath = pkg.Module.Path
+	}
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "type goloaderTestDeps struct{}\n")
+	implemented := map[string]bool{}
+	for _, method := range iface.Methods.List {
+		funcType, ok := method.Type.(*ast.FuncType)
+		if !ok || len(method.Names) != 1 {
+			return "", 0, fmt.Errorf("unsupported testDeps interface element in %s", dir)
+		}
+		name := method.Names[0].Name
+		params, paramTypes, err := g.fieldList(funcType.Params, "p", ifaceImports)
+		if err != nil {
+			return "", 0, fmt.Errorf("testDeps method %s: %w", name, err)
+		}
+		results, resultTypes, err := g.fieldList(funcType.Results, "r", ifaceImports)
+		if err != nil {
+			return "", 0, fmt.Errorf("testDeps method %s: %w", name, err)
+		}
+		body := ""
+		if len(resultTypes) > 0 {
+			body = "return"
+		}
+		switch name {
+		case "ImportPath":
+			body = "return " + strconv.Quote(pkg.ImportPath)
+		case "ModulePath":
+			body = "return " + strconv.Quote(modulePath)
+		case "CoordinateFuzzing", "RunFuzzWorker":
+			if len(resultTypes) > 0 && resultTypes[len(resultTypes)-1] == "error" {
+				body = fmt.Sprintf("r%d = %sNew(\"fuzzing is not supported by goloader\")\n\treturn", len(resultTypes)-1, m.qualifier("errors"))
+			}
+		}
+		if known, ok := testDepsBodies[name]; ok {
+			signature := "(" + strings.Join(paramTypes, ", ") + ") (" + strings.Join(resultTypes, ", ") + ")"
+			expected := known.signature
+			for _, importPath := range known.imports {
+				expected = strings.ReplaceAll(expected, "{"+importPath+"}", importAlias(importPath)+".")
+			}
+			if signature != expected {
+				return "", 0, fmt.Errorf("testDeps method %s in %s has the signature %s, only %s is supported", name, dir, signature, expected)
+			}
+			body = known.body
+			for _, importPath := range known.imports {
+				body = strings.ReplaceAll(body, "{"+importPath+"}", m.qualifier(importPath))
+			}
+			implemented[name] = true
+		}
+		if results != "" {
+			results = " (" + results + ")"
+		}
+		fmt.Fprintf(out, "\nfunc (goloaderTestDeps) %s(%s)%s {\n\t%s\n}\n", name, params, results, body)
+	}
+	if !implemented["MatchString"] {
+		// tests are selected with MatchString, they would never run without it
+		return "", 0, fmt.Errorf("testDeps interface in %s has no MatchString method", dir)
+	}
+	return out.String(), mainStartParams, nil
+}
+
+// hasIntField reports whether structType declares the field name of type int.
+func hasIntField(structType *ast.StructType, name string) bool {
+	for _, field := range structType.Fields.List {
+		for _, fieldName := range field.Names {
+			if fieldName.Name == name {
+				ident, ok := field.Type.(*ast.Ident)
+				return ok && ident.Name == "int"
+			}
+		}
+	}
+	return false
+}
+
+// fieldList returns the parameters of a method named with prefix and an index, and their types.
+func (g *testDepsGenerator) fieldList(fields *ast.FieldList, prefix string, imports map[string]string) (string, []string, error) {
+	if fields == nil {
+		return "", nil, nil
+	}
+	var params, paramTypes []string
+	for _, field := range fields.List {
+		typ, err := g.typeString(field.Type, imports)
+		if err != nil {
+			return "", nil, err
+		}
+		for i := 0; i < len(field.Names) || i == 0 && len(field.Names) == 0; i++ {
+			params = append(params, fmt.Sprintf("%s%d %s", prefix, len(params), typ))
+			paramTypes = append(paramTypes, typ)
+		}
+	}
+	return strings.Join(params, ", "), paramTypes, nil
+}
+
+// typeString prints a type of the testing package as it is spelled in the generated file, aliases
+// of unexported types are replaced by their definition and imported packages by their alias.
+func (g *testDepsGenerator) typeString(expr ast.Expr, imports map[string]string) (string, error) {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if alias, ok := g.aliases[expr.Name]; ok && !ast.IsExported(expr.Name) {
+			return g.typeString(alias, g.imports[alias])
+		}
+		if ast.IsExported(expr.Name) {
+			return g.m.qualifier("testing") + expr.Name, nil
+		}
+		if types.Universe.Lookup(expr.Name) != nil {
+			return expr.Name, nil
+		}
+		return "", fmt.Errorf("unexported type %s of the testing package", expr.Name)
+	case *ast.SelectorExpr:
+		pkgName, ok := expr.X.(*ast.Ident)
+		if !ok || imports[pkgName.Name] == "" {
+			return "", fmt.Errorf("unsupported qualified type")
+		}
+		return g.m.qualifier(imports[pkgName.Name]) + expr.Sel.Name, nil
+	case *ast.StarExpr:
+		elem, err := g.typeString(expr.X, imports)
+		return "*" + elem, err
+	case *ast.Ellipsis:
+		elem, err := g.typeString(expr.Elt, imports)
+		return "..." + elem, err
+	case *ast.ArrayType:
+		length := ""
+		if lit, ok := expr.Len.(*ast.BasicLit); ok {
+			length = lit.Value
+		} else if expr.Len != nil {
+			return "", fmt.Errorf("unsupported array length")
+		}
+		elem, err := g.typeString(expr.Elt, imports)
+		return "[" + length + "]" + elem, err
+	case *ast.MapType:
+		key, err := g.typeString(expr.Key, imports)
+		if err != nil {
+			return "", err
+		}
+		value, err := g.typeString(expr.Value, imports)
+		return "map[" + key + "]" + value, err
+	case *ast.ChanType:
+		elem, err := g.typeString(expr.Value, imports)
+		switch expr.Dir {
+		case ast.SEND:
+			return "chan<- " + elem, err
+		case ast.RECV:
+			return "<-chan " + elem, err
+		}
+		return "chan " + elem, err
+	case *ast.FuncType:
+		params, err := g.typeList(expr.Params, imports)
+		if err != nil {
+			return "", err
+		}
+		results, err := g.typeList(expr.Results, imports)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(results, ",") {
+			results = "(" + results + ")"
+		}
+		return strings.TrimSpace("func(" + params + ") " + results), nil
+	case *ast.StructType:
+		var fields []string
+		for _, field := range expr.Fields.List {
+			typ, err := g.typeString(field.Type, imports)
+			if err != nil {
+				return "", err
+			}
+			var names []string
+			for _, name := range field.Names {
+				names = append(names, name.Name)
+			}
+			decl := strings.TrimSpace(strings.Join(names, ", ") + " " + typ)
+			if field.Tag != nil {
+				decl += " " + field.Tag.Value
+			}
+			fields = append(fields, decl)
+		}
+		return "struct{ " + strings.Join(fields, "; ") + " }", nil
+	case *ast.InterfaceType:
+		if len(expr.Methods.List) == 0 {
+			return "interface{}", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %T", expr)
+}
+
+// typeList prints the types of a parameter list, a type is repeated for every name declared with it.
+func (g *testDepsGenerator) typeList(fields *ast.FieldList, imports map[string]string) (string, error) {
+	if fields == nil {
+		return "", nil
+	}
+	var list []string
+	for _, field := range fields.List {
+		typ, err := g.typeString(field.Type, imports)
+		if err != nil {
+			return "", err
+		}
+		for i := 0; i < len(field.Names) || i == 0 && len(field.Names) == 0; i++ {
+			list = append(list, typ)
+		}
+	}
+	return strings.Join(list, ", "), nil
+}
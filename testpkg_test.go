@@ -0,0 +1,174 @@
+package goloaderbuilder
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGenerateTestMain generates the test main of a package against the testing package of the
+// current GOROOT and type checks it together with the tests with go vet.
+func TestGenerateTestMain(t *testing.T) {
+	env, err := GoEnv("go", "GOROOT")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/p\n\ngo 1.20\n",
+		"p.go":   "package p\n\nfunc Add(a, b int) int { return a + b }\n",
+		"p_internal_test.go": "package p\n\nimport \"testing\"\n\n" +
+			"func TestAdd(t *testing.T) {\n\tif Add(1, 2) != 3 {\n\t\tt.Fail()\n\t}\n}\n\n" +
+			"func BenchmarkAdd(b *testing.B) {\n\tfor i := 0; i < b.N; i++ {\n\t\tAdd(i, i)\n\t}\n}\n",
+		"p_test.go": "package p_test\n\nimport (\n\t\"fmt\"\n\t\"testing\"\n\n\t\"example.com/p\"\n)\n\n" +
+			"func TestMain(m *testing.M) {\n\tm.Run()\n}\n\n" +
+			"func FuzzAdd(f *testing.F) {\n\tf.Fuzz(func(t *testing.T, a int) { p.Add(a, a) })\n}\n\n" +
+			"func ExampleAdd() {\n\tfmt.Println(p.Add(1, 2))\n\t// Output: 3\n}\n",
+	})
+	pkg := &Package{ImportPath: "example.com/p", Name: "p", Dir: dir,
+		TestGoFiles: []string{"p_internal_test.go"}, XTestGoFiles: []string{"p_test.go"}}
+	config := &BuildConfig{goEnv: map[string]string{"GOROOT": env["GOROOT"]}}
+	src, err := config.generateTestMain(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"func " + TestMainEntry + "(args []string) int", `"TestAdd"`, `"BenchmarkAdd"`, `"ExampleAdd"`} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated test main has no %s:\n%s", want, src)
+		}
+	}
+
+	writeFiles(t, dir, map[string]string{"zz_goloader_main_test.go": string(src)})
+	cmd := exec.Command("go", "vet", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off", "GOPROXY=off")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated test main does not compile: %v\n%s\n%s", err, output, src)
+	}
+}
+
+func TestGenerateTestMainUnsupportedTesting(t *testing.T) {
+	testDeps := "package testing\n\ntype testDeps interface {\n\tMatchString(pat, str string) (bool, error)\n}\n\n"
+	mainStart := "func MainStart(deps testDeps, tests []InternalTest, benchmarks []InternalBenchmark, fuzzTargets []InternalFuzzTarget, examples []InternalExample) *M {\n\treturn nil\n}\n"
+	tests := []struct {
+		name    string
+		testing string
+		want    string
+	}{
+		{"no testDeps", "package testing\n\n" + mainStart, "no testDeps interface or MainStart function"},
+		{"MainStart parameters", testDeps + "func MainStart(deps testDeps) *M {\n\treturn nil\n}\n", "takes 1 parameters"},
+		{"MatchString signature", strings.Replace(testDeps, "(bool, error)", "bool", 1) + mainStart, "has the signature (string, string) (bool)"},
+		{"no MatchString", "package testing\n\ntype testDeps interface {\n\tImportPath() string\n}\n\n" + mainStart, "has no MatchString method"},
+		{"no exitCode", testDeps + mainStart + "\ntype M struct {\n\tcode int\n}\n", "has no integer exitCode field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goroot := t.TempDir()
+			writeFiles(t, goroot, map[string]string{"src/testing/testing.go": tt.testing})
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"p_test.go": "package p_test\n\nimport \"testing\"\n\nfunc TestMain(m *testing.M) {\n\tm.Run()\n}\n"})
+			pkg := &Package{ImportPath: "example.com/p", Name: "p", Dir: dir, XTestGoFiles: []string{"p_test.go"}}
+			config := &BuildConfig{goEnv: map[string]string{"GOROOT": goroot}}
+			if _, err := config.generateTestMain(pkg); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("generateTestMain() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTestGoVersion(t *testing.T) {
+	for _, goVersion := range []string{"go1.20", "go1.22.5", "go1.27.1 X:nocoverageredesign", "go1.25rc1"} {
+		if err := checkTestGoVersion(goVersion); err != nil {
+			t.Errorf("checkTestGoVersion(%q) = %v, want nil", goVersion, err)
+		}
+	}
+	for _, goVersion := range []string{"go1.19.13", "go1.2", "go1.99.0", "devel go1.28-abcdef", ""} {
+		if err := checkTestGoVersion(goVersion); err == nil || !strings.Contains(err.Error(), "supported go releases are go1.20") {
+			t.Errorf("checkTestGoVersion(%q) = %v, want an unsupported go toolchain error", goVersion, err)
+		}
+	}
+}
+
+// TestBuildTestPackageRun builds the tests of a package with BuildTestPackage, then links the same
+// test packages and generated test main into a plain executable standing in for the goloader host
+// and runs the entry with test flags.
+func TestBuildTestPackageRun(t *testing.T) {
+	env, err := GoEnv("go", "GOROOT", "GOVERSION")
+	if err != nil {
+		t.Skip(err)
+	}
+	if err = checkTestGoVersion(env["GOVERSION"]); err != nil {
+		t.Skip(err)
+	}
+	const (
+		goMod        = "module example.com/p\n\ngo 1.20\n"
+		source       = "package p\n\nfunc Add(a, b int) int { return a + b }\n"
+		internalTest = "package p\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(1, 2) != 3 {\n\t\tt.Fail()\n\t}\n}\n"
+		externalTest = "package p_test\n\nimport (\n\t\"fmt\"\n\t\"testing\"\n\n\t\"example.com/p\"\n)\n\n" +
+			"func TestFail(t *testing.T) {\n\tt.Fatal(\"failed on purpose\")\n}\n\n" +
+			"func ExampleAdd() {\n\tfmt.Println(p.Add(1, 2))\n\t// Output: 3\n}\n"
+	)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": goMod, "p.go": source, "p_internal_test.go": internalTest, "p_test.go": externalTest})
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOPROXY", "off")
+	config := &BuildConfig{BuildPaths: []string{dir}, WorkDir: dir, KeepWorkDir: true, TargetDir: t.TempDir()}
+	result, err := BuildTestPackage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "example.com/p_test." + TestMainEntry; result.Entry != want {
+		t.Errorf("Entry = %s, want %s", result.Entry, want)
+	}
+	for _, path := range append([]string{result.TargetPath}, result.DepFiles...) {
+		if _, err = os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+
+	pkg := &Package{ImportPath: "example.com/p", Name: "p", Dir: dir,
+		TestGoFiles: []string{"p_internal_test.go"}, XTestGoFiles: []string{"p_test.go"}}
+	src, err := (&BuildConfig{goEnv: map[string]string{"GOROOT": env["GOROOT"]}}).generateTestMain(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := t.TempDir()
+	writeFiles(t, host, map[string]string{
+		"go.mod":        goMod,
+		"p.go":          source,
+		"p_internal.go": internalTest,
+		"ptest/p.go":    externalTest,
+		"ptest/main.go": string(src),
+		"host/main.go": "package main\n\nimport (\n\t\"os\"\n\n\tp_test \"example.com/p/ptest\"\n)\n\n" +
+			"func main() {\n\tos.Exit(p_test." + TestMainEntry + "(os.Args[1:]))\n}\n",
+	})
+	exe := filepath.Join(host, "host.exe")
+	cmd := exec.Command("go", "build", "-o", exe, "./host")
+	cmd.Dir = host
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not build the host: %v\n%s", err, output)
+	}
+	tests := []struct {
+		run      string
+		exitCode int
+		output   string
+	}{
+		{run: "^(TestAdd|ExampleAdd)$", exitCode: 0, output: "--- PASS: ExampleAdd"},
+		{run: "^TestFail$", exitCode: 1, output: "failed on purpose"},
+	}
+	for _, tt := range tests {
+		output, err := exec.Command(exe, "-test.v", "-test.run", tt.run).CombinedOutput()
+		exitCode := 0
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if exitCode != tt.exitCode || !strings.Contains(string(output), tt.output) {
+			t.Errorf("-test.run %s: exit code %d, want %d, output:\n%s", tt.run, exitCode, tt.exitCode, output)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package goloaderbuilder
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"time"
+)
+
+type Invocation struct {
+	Dir  string   // working directory of the go command
+	Env  []string // environment of the go command, nil means inherit the current process environment
+	Args []string // arguments following the go subcommand
+}
+
+// Toolchain runs go subcommands on behalf of the builder. Every method returns the
+// stdout of the command, a failed command is reported as *BuildError.
+type Toolchain interface {
+	List(ctx context.Context, inv *Invocation) ([]byte, error)  // go list
+	Build(ctx context.Context, inv *Invocation) ([]byte, error) // go build
+	Mod(ctx context.Context, inv *Invocation) ([]byte, error)   // go mod, the first argument is the mod subcommand
+	Get(ctx context.Context, inv *Invocation) ([]byte, error)   // go get
+	Env(ctx context.Context, inv *Invocation) ([]byte, error)   // go env
+}
+
+// ToolRunner is implemented by toolchains which also run go tool commands, such as go tool nm.
+// The builder runs them with an ExecToolchain using GoBinary when the Toolchain does not implement it.
+type ToolRunner interface {
+	Tool(ctx context.Context, inv *Invocation) ([]byte, error) // go tool, the first argument is the tool name
+}
+
+type ExecToolchain struct {
+	GoBinary string // path to go binary, defaults to "go"
+}
+
+const commandWaitDelay = 5 * time.Second
+
+func goCommand(ctx context.Context, goCmd string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, goCmd, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+func (t *ExecToolchain) run(ctx context.Context, subcommand string, inv *Invocation) ([]byte, error) {
+	goBinary := t.GoBinary
+	if goBinary == "" {
+		goBinary = "go"
+	}
+	cmd := goCommand(ctx, goBinary, append([]string{subcommand}, inv.Args...)...)
+	cmd.Dir = inv.Dir
+	cmd.Env = inv.Env
+
+	stdoutBuffer := &bytes.Buffer{}
+	stderrBuffer := &bytes.Buffer{}
+	cmd.Stdout = stdoutBuffer
+	cmd.Stderr = stderrBuffer
+
+	if err := cmd.Run(); err != nil {
+		buildErr := &BuildError{
+			Args:     cmd.Args,
+			ExitCode: -1,
+			Stdout:   stdoutBuffer.String(),
+			Stderr:   stderrBuffer.String(),
+			Err:      err,
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			buildErr.ExitCode = exitErr.ExitCode()
+		}
+		if ctx.Err() != nil {
+			buildErr.Err = ctx.Err()
+		}
+		buildErr.Diagnostics = parseDiagnostics(buildErr.Stderr, cmd.Dir)
+		return stdoutBuffer.Bytes(), buildErr
+	}
+	return stdoutBuffer.Bytes(), nil
+}
+
+func (t *ExecToolchain) List(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.run(ctx, "list", inv)
+}
+
+func (t *ExecToolchain) Build(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.run(ctx, "build", inv)
+}
+
+func (t *ExecToolchain) Mod(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.run(ctx, "mod", inv)
+}
+
+func (t *ExecToolchain) Get(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.run(ctx, "get", inv)
+}
+
+func (t *ExecToolchain) Env(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.run(ctx, "env", inv)
+}
+
+func (t *ExecToolchain) Tool(ctx context.Context, inv *Invocation) ([]byte, error) {
+	return t.run(ctx, "tool", inv)
+}
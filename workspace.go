@@ -0,0 +1,64 @@
+package goloaderbuilder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ModMode string
+
+const (
+	ModDefault  ModMode = ""         // let the go command choose, vendor when the main module has a consistent vendor directory
+	ModMod      ModMode = "mod"      // ignore the vendor directory and update go.mod if needed
+	ModReadonly ModMode = "readonly" // ignore the vendor directory and fail if go.mod needs updates
+	ModVendor   ModMode = "vendor"   // load packages of dependencies from the vendor directory
+)
+
+// workEnv returns the GOWORK setting of the config, the go.work file is made absolute
+// because dependencies are built from a different directory than the root package.
+func (config *BuildConfig) workEnv() []string {
+	if config.GoWork == "" {
+		return nil
+	}
+	if config.GoWork == "off" {
+		return []string{"GOWORK=off"}
+	}
+	path, err := filepath.Abs(config.GoWork)
+	if err != nil {
+		return []string{"GOWORK=" + config.GoWork}
+	}
+	return []string{"GOWORK=" + path}
+}
+
+// workspace returns the go.work file used by the build, or "" in single module mode.
+func (config *BuildConfig) workspace() string {
+	goWork := config.goEnv["GOWORK"]
+	if goWork == "off" || goWork == os.DevNull {
+		return ""
+	}
+	return goWork
+}
+
+// checkResolvable reports why missing dependencies of pkg can not be added by resolveDependencies.
+func (config *BuildConfig) checkResolvable(pkg *Package) error {
+	if config.ModMode == ModVendor || loadedFromVendor(pkg) {
+		return fmt.Errorf("%w\nrun 'go mod vendor' to copy the missing packages into the vendor directory", packageErrors(pkg))
+	}
+	if config.ResolveMode == ResolveModfile && config.workspace() != "" {
+		return fmt.Errorf("%w\n-modfile can not be used in the workspace %s, use ResolveModify or set GoWork to off", packageErrors(pkg), config.workspace())
+	}
+	return nil
+}
+
+// loadedFromVendor reports whether go list looked up the dependencies of pkg in a vendor
+// directory, which the go command does by default when the main module has one.
+func loadedFromVendor(pkg *Package) bool {
+	for _, depErr := range pkg.DepsErrors {
+		if strings.Contains(depErr.Err, "-mod=vendor") {
+			return true
+		}
+	}
+	return false
+}